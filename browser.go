@@ -0,0 +1,229 @@
+package main
+
+/**
+ * Opening things in the web browser
+ *
+ * Copyright (C) 2018 Arthur M
+ */
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+/* Get the commands that can open a web page
+ * $BROWSER can have more than one, separated by ':', like
+ * 'firefox:chromium'. If it isn't set, use the one of the system
+ */
+func getBrowserCommands() []string {
+	if browser := os.Getenv("BROWSER"); browser != "" {
+		return strings.Split(browser, ":")
+	}
+
+	switch runtime.GOOS {
+	case "darwin":
+		return []string{"open"}
+	case "windows":
+		return []string{"rundll32 url.dll,FileProtocolHandler"}
+	}
+
+	return []string{"xdg-open", "x-www-browser", "sensible-browser"}
+}
+
+/* Open 'url' in the web browser
+ * Don't wait for the browser to be closed
+ */
+func openBrowser(url string) error {
+	for _, browser := range getBrowserCommands() {
+		fields := strings.Fields(browser)
+		if len(fields) == 0 {
+			continue
+		}
+
+		// Like in $BROWSER, '%s' is where the URL goes
+		args := make([]string, 0, len(fields))
+		hasURL := false
+		for _, field := range fields[1:] {
+			if strings.Contains(field, "%s") {
+				field = strings.Replace(field, "%s", url, -1)
+				hasURL = true
+			}
+			args = append(args, field)
+		}
+		if !hasURL {
+			args = append(args, url)
+		}
+
+		path, err := exec.LookPath(fields[0])
+		if err != nil {
+			continue
+		}
+
+		cmd := exec.Command(path, args...)
+		if err := cmd.Start(); err != nil {
+			continue
+		}
+
+		go cmd.Wait()
+		return nil
+	}
+
+	return errors.New("No web browser found. Set one in $BROWSER")
+}
+
+/* Check if the repository is on GitHub, by its host name
+ * The URLs of some pages are different between GitHub and GitLab, and this
+ * way we don't need the network to know them
+ */
+func isGitHubRepository(repo *TRepository) bool {
+	return strings.Contains(strings.ToLower(repo.base_url), "github")
+}
+
+/* Get the web page of the repository 'repo' */
+func repositoryWebURL(repo *TRepository) string {
+	return "https://" + repo.base_url + "/" + repo.author + "/" + repo.name
+}
+
+/* Get the web page of the file 'path' in the current branch, at 'line'
+ * (0 for no line). 'path' is relative to the current directory
+ */
+func fileWebURL(repo *TRepository, path string, line int) (string, error) {
+	prefix, err := exec.Command("git", "rev-parse", "--show-prefix").Output()
+	if err != nil {
+		return "", err
+	}
+
+	// A detached HEAD has no branch name, so use the commit
+	ref, err := exec.Command("git", "symbolic-ref", "-q", "--short", "HEAD").Output()
+	if err != nil {
+		if ref, err = exec.Command("git", "rev-parse", "HEAD").Output(); err != nil {
+			return "", err
+		}
+	}
+
+	relpath := filepath.ToSlash(filepath.Clean(
+		filepath.Join(strings.TrimSpace(string(prefix)), path)))
+	if strings.HasPrefix(relpath, "../") {
+		return "", errors.New(path + " is outside the repository")
+	}
+
+	// Gitlab serves files under /-/blob/, and /blob/ only redirects there
+	blob := "/-/blob/"
+	if isGitHubRepository(repo) {
+		blob = "/blob/"
+	}
+
+	weburl := repositoryWebURL(repo) + blob +
+		escapeURLPath(strings.TrimSpace(string(ref))) + "/" + escapeURLPath(relpath)
+	if line > 0 {
+		weburl += "#L" + strconv.Itoa(line)
+	}
+
+	return weburl, nil
+}
+
+/* Escape each segment of the slash separated 'path', so branch and file
+ * names with '#', '?' or spaces don't break the URL
+ */
+func escapeURLPath(path string) string {
+	segments := strings.Split(path, "/")
+	for idx, segment := range segments {
+		segments[idx] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+/* Get the web page 'args' point to, for the browse command */
+func getBrowseURL(repo *TRepository, args []string) string {
+	weburl := repositoryWebURL(repo)
+	if len(args) == 0 || args[0] == "issues" {
+		return weburl + "/issues"
+	}
+
+	if args[0] == "new" {
+		return weburl + "/issues/new"
+	}
+
+	if args[0] == "pr" || args[0] == "mr" {
+		if len(args) < 2 {
+			if isGitHubRepository(repo) {
+				return weburl + "/pulls"
+			}
+			return weburl + "/merge_requests"
+		}
+
+		number := parseIssueNumber(args)
+		if isGitHubRepository(repo) {
+			return weburl + "/pull/" + strconv.Itoa(int(number))
+		}
+		return weburl + "/merge_requests/" + strconv.Itoa(int(number))
+	}
+
+	// On GitHub, pull requests are issues too, and it redirects to them
+	if number, err := strconv.ParseUint(strings.TrimPrefix(args[0], "#"), 10, 32); err == nil {
+		return weburl + "/issues/" + strconv.FormatUint(number, 10)
+	}
+
+	// Then it must be a file, maybe with a line, like 'main.go:42'
+	path, line := args[0], 0
+	if idx := strings.LastIndex(path, ":"); idx > 0 {
+		if l, err := strconv.Atoi(path[idx+1:]); err == nil {
+			path, line = path[:idx], l
+		}
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		panic("No issue or file named " + args[0])
+	}
+
+	url, err := fileWebURL(repo, path, line)
+	if err != nil {
+		panic(err)
+	}
+
+	return url
+}
+
+func _browse(ctx context.Context, ad ArgumentData, args []string) {
+	if len(args) > 1 && args[1] == "help" {
+		fmt.Println(args[0] + " [<issue_num>|new|pr [<pr_num>]|<file>[:<line>]] [--print]")
+		fmt.Println(" Open the issue tracker of the repository in the web browser, or:")
+		fmt.Println(" \t<issue_num> - the issue (or pull request, on GitHub)")
+		fmt.Println(" \tnew - the page to create an issue")
+		fmt.Println(" \tpr [<pr_num>] - the pull (or merge) requests, or one of them")
+		fmt.Println(" \t<file>[:<line>] - the file in the current branch")
+		fmt.Println()
+		fmt.Println(" --print only prints the URL. The browser is the one in $BROWSER, or")
+		fmt.Println(" the one of the system")
+		fmt.Println()
+		return
+	}
+
+	params := make([]string, 0, len(args))
+	printOnly := false
+	for _, arg := range args[1:] {
+		if arg == "--print" {
+			printOnly = true
+			continue
+		}
+		params = append(params, arg)
+	}
+
+	url := getBrowseURL(getCurrentRepository(), params)
+	if printOnly {
+		fmt.Println(url)
+		return
+	}
+
+	if err := openBrowser(url); err != nil {
+		panic(err)
+	}
+}
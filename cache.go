@@ -0,0 +1,566 @@
+package main
+
+/**
+ * Local issue cache
+ *
+ * Downloading every issue on every invocation is slow, so we keep a copy of
+ * the issues and their comments inside the git directory
+ * ('.git/shissue/cache.json'). The 'sync' command updates it, only asking
+ * the host for what changed since the last time.
+ *
+ * Copyright (C) 2018 Arthur M
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/* Cache file format version. Change it when the format changes in an
+ * incompatible way, so old caches are thrown away instead of misread
+ *
+ * Version 3 has the usernames of the Gitlab authors and assignees, instead
+ * of their names. Version 4 has the comment counts of the host, and
+ * version 5 tells the Github pull requests apart. Version 6 has the
+ * usernames of the Gitlab comment authors too
+ */
+const issueCacheVersion = 6
+
+/* How long after a sync the cache is considered fresh, by default */
+const defaultCacheTTL = 5 * time.Minute
+
+/* Structures that represent the cache file contents
+ * The issue structures have unexported fields, so we can't store them
+ * directly
+ */
+type TCachedLabel struct {
+	Name  string `json:"name"`
+	Color string `json:"color"` // Hex color, like 'ff0000'
+}
+
+type TCachedComment struct {
+	ID       uint      `json:"id"`
+	URL      string    `json:"url"`
+	Author   string    `json:"author"`
+	Creation time.Time `json:"created_at"`
+	Content  string    `json:"body"`
+}
+
+type TCachedIssue struct {
+	ID        uint             `json:"id"`
+	Number    uint             `json:"number"`
+	Name      string           `json:"title"`
+	URL       string           `json:"url"`
+	Author    string           `json:"author"`
+	Assignees []string         `json:"assignees"`
+	Labels    []TCachedLabel   `json:"labels"`
+	Milestone string           `json:"milestone,omitempty"`
+	Creation  time.Time        `json:"created_at"`
+	Updated   time.Time        `json:"updated_at"`
+	Content   string           `json:"body"`
+	IsClosed  bool             `json:"closed"`
+	Comments  []TCachedComment `json:"comments"`
+
+	// What the host says, since we might not have all comments
+	CommentCount int  `json:"comment_count"`
+	PullRequest  bool `json:"pull_request,omitempty"`
+}
+
+type TIssueCache struct {
+	Version    int    `json:"version"`
+	Repository string `json:"repository"` // Repository the issues are from
+
+	// When we last synced, in our clock. Used to know if the cache is fresh
+	LastSync time.Time `json:"last_sync"`
+
+	// Most recent update date of all issues, in the host clock. Used to
+	// know what changed since the last sync
+	LastUpdated time.Time `json:"last_updated"`
+
+	Issues map[uint]*TCachedIssue `json:"issues"`
+
+	path string // Where the cache file is
+}
+
+/* Get the name we identify a repository with in the cache */
+func cacheRepositoryName(repo *TRepository) string {
+	return repo.base_url + "/" + repo.author + "/" + repo.name
+}
+
+/* Open the issue cache of the repository 'repo'
+ * If it doesn't exist yet, or it's from another repository, return an empty
+ * one. It will be created when saved.
+ */
+func openIssueCache(repo *TRepository) (*TIssueCache, error) {
+	gitdir, err := getGitDir()
+	if err != nil {
+		return nil, err
+	}
+
+	cache := &TIssueCache{
+		Version:    issueCacheVersion,
+		Repository: cacheRepositoryName(repo),
+		Issues:     make(map[uint]*TCachedIssue),
+		path:       filepath.Join(gitdir, "shissue", "cache.json"),
+	}
+
+	data, err := ioutil.ReadFile(cache.path)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var stored TIssueCache
+	if err := json.Unmarshal(data, &stored); err != nil {
+		// A broken cache is not worth dying for. Start a new one
+		return cache, nil
+	}
+
+	if stored.Version != issueCacheVersion ||
+		stored.Repository != cache.Repository {
+		return cache, nil
+	}
+
+	if stored.Issues == nil {
+		stored.Issues = make(map[uint]*TCachedIssue)
+	}
+
+	stored.path = cache.path
+	return &stored, nil
+}
+
+/* Save the cache to its file
+ * Write it to a temporary file first, so we never leave a half-written
+ * cache behind
+ */
+func (c *TIssueCache) save() error {
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	tmppath := c.path + ".tmp"
+	if err := ioutil.WriteFile(tmppath, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmppath, c.path)
+}
+
+/* Check if the cache was synced less than 'ttl' ago */
+func (c *TIssueCache) isFresh(ttl time.Duration) bool {
+	return !c.LastSync.IsZero() && time.Since(c.LastSync) < ttl
+}
+
+/* Store an issue and its comments, replacing what we had for it */
+func (c *TIssueCache) storeIssue(issue TIssue, comments []TIssueComment) {
+	cissue := &TCachedIssue{
+		ID:        issue.id,
+		Number:    issue.number,
+		Name:      issue.name,
+		URL:       issue.url,
+		Author:    issue.author,
+		Assignees: issue.assignees,
+		Labels:    make([]TCachedLabel, 0, len(issue.labels)),
+		Milestone: issue.milestone,
+		Creation:  issue.creation,
+		Updated:   issue.updated,
+		Content:   issue.content,
+		IsClosed:  issue.is_closed,
+		Comments:  make([]TCachedComment, 0, len(comments)),
+
+		CommentCount: issue.commentCount,
+		PullRequest:  issue.isPullRequest,
+	}
+
+	for _, label := range issue.labels {
+		cissue.Labels = append(cissue.Labels, TCachedLabel{
+			Name:  label.name,
+			Color: label.hexColor(),
+		})
+	}
+
+	for _, comment := range comments {
+		cissue.Comments = append(cissue.Comments, TCachedComment{
+			ID:       comment.id,
+			URL:      comment.url,
+			Author:   comment.author,
+			Creation: comment.creation,
+			Content:  comment.content,
+		})
+	}
+
+	c.Issues[issue.number] = cissue
+	if issue.updated.After(c.LastUpdated) {
+		c.LastUpdated = issue.updated
+	}
+}
+
+/* Convert a cached issue back to our issue format */
+func (cissue *TCachedIssue) toIssue() TIssue {
+	labels := make([]TIssueLabel, 0, len(cissue.Labels))
+	for _, label := range cissue.Labels {
+		labels = append(labels, newIssueLabel(label.Name, label.Color))
+	}
+
+	return TIssue{
+		id:        cissue.ID,
+		number:    cissue.Number,
+		name:      cissue.Name,
+		url:       cissue.URL,
+		author:    cissue.Author,
+		assignees: cissue.Assignees,
+		labels:    labels,
+		milestone: cissue.Milestone,
+		creation:  cissue.Creation,
+		updated:   cissue.Updated,
+		content:   cissue.Content,
+		is_closed: cissue.IsClosed,
+
+		commentCount:  cissue.CommentCount,
+		isPullRequest: cissue.PullRequest,
+	}
+}
+
+/* Get the issue numbered 'number' and its comments
+ * Return nil if we don't have it
+ */
+func (c *TIssueCache) getIssue(number uint) (*TIssue, []TIssueComment) {
+	cissue, ok := c.Issues[number]
+	if !ok {
+		return nil, nil
+	}
+
+	issue := cissue.toIssue()
+	comments := make([]TIssueComment, 0, len(cissue.Comments))
+	for _, ccomment := range cissue.Comments {
+		comments = append(comments, TIssueComment{
+			id:       ccomment.ID,
+			url:      ccomment.URL,
+			author:   ccomment.Author,
+			creation: ccomment.Creation,
+			content:  ccomment.Content,
+		})
+	}
+
+	return &issue, comments
+}
+
+/* Check if the cached issue 'cissue' matches 'filter'
+ * Unlike issueMatchesFilter(), the mentions are looked for in the
+ * comments too, like the hosts do
+ */
+func (cissue *TCachedIssue) matchesFilter(issue *TIssue, filter TIssueFilter) bool {
+	mentioned := filter.mentioned
+	filter.mentioned = nil
+	if !issueMatchesFilter(issue, filter) {
+		return false
+	}
+
+	if mentioned == nil || mentionsUser(*mentioned, issue.content) {
+		return true
+	}
+
+	for _, comment := range cissue.Comments {
+		if mentionsUser(*mentioned, comment.Content) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/* Get the issues that match 'filter', newest first, like the hosts do
+ * If the filter has a search text, the issues that match it better come
+ * first, unless the filter asks for a sort order
+ */
+func (c *TIssueCache) getIssues(filter TIssueFilter) []TIssue {
+	if filter.search != nil {
+		issues := make([]TIssue, 0)
+		for _, number := range buildSearchIndex(c).search(*filter.search) {
+			cissue := c.Issues[number]
+			issue := cissue.toIssue()
+			if cissue.matchesFilter(&issue, filter) {
+				issues = append(issues, issue)
+			}
+		}
+
+		if filter.sortBy != "" || filter.ascending {
+			sortIssues(issues, filter)
+		}
+
+		if filter.limit > 0 && uint(len(issues)) > filter.limit {
+			issues = issues[:filter.limit]
+		}
+
+		return issues
+	}
+
+	issues := make([]TIssue, 0, len(c.Issues))
+	for _, cissue := range c.Issues {
+		issue := cissue.toIssue()
+		if cissue.matchesFilter(&issue, filter) {
+			issues = append(issues, issue)
+		}
+	}
+
+	sortIssues(issues, filter)
+
+	if filter.limit > 0 && uint(len(issues)) > filter.limit {
+		issues = issues[:filter.limit]
+	}
+
+	return issues
+}
+
+/* Check if 'user' matches a filter user value
+ * Like the Github API, 'none' means no user and '*' means any user
+ */
+func userMatchesFilter(users []string, value string) bool {
+	if value == "none" {
+		return len(users) == 0
+	}
+
+	if value == "*" {
+		return len(users) > 0
+	}
+
+	for _, user := range users {
+		if strings.EqualFold(user, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/* Check if an issue matches 'filter', the same way the hosts would
+ * The search text is not checked here, since it needs the comments too.
+ * getIssues() checks it with the search index
+ */
+func issueMatchesFilter(issue *TIssue, filter TIssueFilter) bool {
+	if issue.is_closed && !filter.getClosed {
+		return false
+	}
+
+	if !issue.is_closed && !filter.getOpen {
+		return false
+	}
+
+	if filter.assignee != nil &&
+		!userMatchesFilter(issue.assignees, *filter.assignee) {
+		return false
+	}
+
+	if filter.creator != nil &&
+		!userMatchesFilter([]string{issue.author}, *filter.creator) {
+		return false
+	}
+
+	if filter.since != nil && issue.updated.Before(*filter.since) {
+		return false
+	}
+
+	if filter.until != nil && issue.updated.After(*filter.until) {
+		return false
+	}
+
+	if filter.createdSince != nil && issue.creation.Before(*filter.createdSince) {
+		return false
+	}
+
+	if filter.noLabels && len(issue.labels) > 0 {
+		return false
+	}
+
+	// Here we only have the issue content. getIssues() checks the comments
+	if filter.mentioned != nil && !mentionsUser(*filter.mentioned, issue.content) {
+		return false
+	}
+
+	if filter.milestone != nil {
+		switch strings.ToLower(*filter.milestone) {
+		case "none":
+			if issue.milestone != "" {
+				return false
+			}
+		case "any":
+			if issue.milestone == "" {
+				return false
+			}
+		default:
+			if !strings.EqualFold(issue.milestone, *filter.milestone) {
+				return false
+			}
+		}
+	}
+
+	// The issue needs to have all labels of the filter
+	if filter.labels != nil {
+		for _, flabel := range *filter.labels {
+			found := false
+			for _, label := range issue.labels {
+				if strings.EqualFold(label.name, flabel.name) {
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+/* Update the cache with what changed in the host since the last sync
+ * If 'full' is true, download everything again.
+ *
+ * If it fails in the middle, keep what we got, so the next sync starts
+ * where this one stopped
+ *
+ * Return the number of issues that were updated
+ */
+func syncIssueCache(ctx context.Context, r TRepoHost, auth *TAuthentication,
+	cache *TIssueCache, full bool) (int, error) {
+
+	filter := TIssueFilter{getOpen: true, getClosed: true}
+	if !full && !cache.LastUpdated.IsZero() {
+		since := cache.LastUpdated
+		filter.since = &since
+	}
+
+	syncstart := time.Now()
+	issues, err := r.DownloadAllIssues(ctx, auth, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	// Download the comments of the updated issues at the same time, like
+	// we do with the issue pages. Most issues have none, so don't ask
+	comments := make([][]TIssueComment, len(issues))
+	downloaded := make([]bool, len(issues))
+	err = fetchPages(ctx, 0, len(issues)-1, func(ctx context.Context, idx int) error {
+		if issues[idx].commentCount == 0 {
+			downloaded[idx] = true
+			return nil
+		}
+
+		icomments, err := r.DownloadIssueComments(ctx, auth,
+			issues[idx].number)
+		comments[idx] = icomments
+		downloaded[idx] = err == nil
+		return err
+	})
+
+	if err != nil {
+		// Store what we have, but make the next sync ask again for the
+		// issues we don't have, from the oldest one
+		var missing *time.Time
+		stored := 0
+		for idx, issue := range issues {
+			if !downloaded[idx] {
+				if missing == nil || issue.updated.Before(*missing) {
+					missing = &issues[idx].updated
+				}
+				continue
+			}
+
+			cache.storeIssue(issue, comments[idx])
+			stored++
+		}
+
+		if missing != nil && missing.Before(cache.LastUpdated) {
+			cache.LastUpdated = *missing
+		}
+
+		if serr := cache.save(); serr != nil {
+			return stored, serr
+		}
+		return stored, err
+	}
+
+	if full {
+		cache.Issues = make(map[uint]*TCachedIssue)
+		cache.LastUpdated = time.Time{}
+	}
+
+	for idx, issue := range issues {
+		cache.storeIssue(issue, comments[idx])
+	}
+
+	cache.LastSync = syncstart
+	return len(issues), cache.save()
+}
+
+/* Get how long the cache stays fresh after a sync
+ * It can be set with 'git config shissue.cacheTTL <duration>', like '1h'.
+ * '0' disables reading from the cache (but 'sync' still updates it)
+ */
+func getCacheTTL() time.Duration {
+	sttl, err := getGitProperty("shissue.cacheTTL")
+	if err != nil {
+		return defaultCacheTTL
+	}
+
+	ttl, err := time.ParseDuration(sttl)
+	if err != nil {
+		return defaultCacheTTL
+	}
+
+	return ttl
+}
+
+func _syncIssues(ctx context.Context, ad ArgumentData, args []string) {
+	full := false
+	if len(args) > 1 {
+		switch args[1] {
+		case "full":
+			full = true
+		case "help":
+			fmt.Println(args[0] + " [full]")
+			fmt.Println(" Update the local issue cache with what changed since the last sync")
+			fmt.Println(" 'full' downloads everything again")
+			fmt.Println()
+			fmt.Println(" Listings read from the cache for 5 minutes after a sync. Change it")
+			fmt.Println(" with 'git config shissue.cacheTTL <duration>', or skip the cache")
+			fmt.Println(" with --no-cache")
+			fmt.Println()
+			return
+		}
+	}
+
+	cache, err := openIssueCache(getCurrentRepository())
+	if err != nil {
+		panic(err)
+	}
+
+	r := getRepositoryHost(ctx, ad.auth)
+	count, err := syncIssueCache(ctx, r, ad.auth, cache, full)
+	if err != nil {
+		panic(err)
+	}
+
+	if isStructuredFormat(ad.format) {
+		printStructured(ad.format, TJSONSync{Updated: count,
+			Cached: len(cache.Issues), SyncedAt: cache.LastSync})
+		return
+	}
+
+	fmt.Printf("Synced %d updated issues (%d issues in the cache)\n",
+		count, len(cache.Issues))
+}
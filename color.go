@@ -0,0 +1,139 @@
+package main
+
+/**
+ * Terminal colors
+ *
+ * We only color the output when it goes to a terminal that can show it,
+ * like git does. --color=always|never|auto overrides that, and NO_COLOR
+ * (https://no-color.org) turns colors off in auto mode.
+ *
+ * Label colors are shown with as many colors as the terminal supports:
+ * 24-bit, 256 colors, or the basic 8, based on COLORTERM and TERM.
+ *
+ * Copyright (C) 2018 Arthur M
+ */
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/* Color modes, for --color */
+const (
+	colorAuto   = "auto"
+	colorAlways = "always"
+	colorNever  = "never"
+)
+
+/* How many colors the terminal can show */
+const (
+	colorDepth8 = iota
+	colorDepth256
+	colorDepthTrue
+)
+
+/* If we are coloring the output. Set by setColorMode() */
+var useColors = true
+
+/* Decide if we color the output, based on the --color 'mode' */
+func setColorMode(mode string) {
+	switch mode {
+	case colorAlways:
+		useColors = true
+	case colorNever:
+		useColors = false
+	default:
+		useColors = os.Getenv("NO_COLOR") == "" &&
+			os.Getenv("TERM") != "dumb" && isTerminal(os.Stdout)
+	}
+}
+
+/* Get how many colors the terminal can show */
+func terminalColorDepth() int {
+	colorterm := os.Getenv("COLORTERM")
+	if colorterm == "truecolor" || colorterm == "24bit" {
+		return colorDepthTrue
+	}
+
+	term := os.Getenv("TERM")
+	if strings.HasSuffix(term, "-direct") {
+		return colorDepthTrue
+	}
+
+	// Terminals that set COLORTERM at all can do at least 256 colors
+	if strings.Contains(term, "256color") || colorterm != "" {
+		return colorDepth256
+	}
+
+	return colorDepth8
+}
+
+/* Write 's' in the ANSI style 'code', like '1' for bold */
+func ansiStyle(code, s string) string {
+	if !useColors || code == "" || s == "" {
+		return s
+	}
+	return "\033[" + code + "m" + s + "\033[0m"
+}
+
+/* Write the string 's' with a background color 'r','g','b'
+ * It will convert the color to one the terminal supports
+ */
+func backColor(s string, r, g, b uint8) string {
+	if !useColors {
+		return s
+	}
+
+	switch terminalColorDepth() {
+	case colorDepthTrue:
+		// (255 / 51 = 5, the number we have to limit it to convert the
+		cR, cG, cB := float32(r/51.0), float32(g/51.0), float32(b/51.0)
+
+		if cR+cG*2.5+cB > 9.0 {
+			s = "\033[30m" + s
+		}
+		return fmt.Sprintf("\033[48;2;%d;%d;%dm%s\033[0m",
+			r, g, b, s)
+
+	case colorDepth256:
+		// (255 / 51 = 5, the number we have to limit it to convert the
+		// number to a 256-color compatible one
+		cR, cG, cB := r/51, g/51, b/51
+
+		if cR+uint8(float32(cG)*2.5)+cB > 9 {
+			s = "\033[30m" + s
+		}
+
+		// taken from https://en.wikipedia.org/wiki/ANSI_escape_code#8-bit
+		cColorNum := 16 + 36*cR + 6*cG + cB
+
+		return "\033[48;5;" + strconv.Itoa(int(cColorNum)) +
+			"m" + s + "\033[0m"
+	}
+
+	// The basic colors are one bit for each of red, green and blue
+	cColorNum := 0
+	if r > 127 {
+		cColorNum |= 1
+	}
+	if g > 127 {
+		cColorNum |= 2
+	}
+	if b > 127 {
+		cColorNum |= 4
+	}
+
+	// Yellow, cyan and white need black text
+	if cColorNum == 3 || cColorNum >= 6 {
+		s = "\033[30m" + s
+	}
+
+	return "\033[" + strconv.Itoa(40+cColorNum) + "m" + s + "\033[0m"
+}
+
+/* Write a label as a colored chip, like the issue listing shows them */
+func labelChip(label TIssueLabel) string {
+	return backColor(" "+label.name+" ", label.colorR, label.colorG, label.colorB)
+}
@@ -0,0 +1,224 @@
+package main
+
+/**
+ * Issue filters, shared by all commands and repository hosts
+ *
+ * The issue listing, the search, the picker and the TUI filter bar all
+ * build their filters here, and the hosts use the helpers here to do
+ * locally what their APIs can't: some filters, and some sort orders.
+ *
+ * Copyright (C) 2018 Arthur M
+ */
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* Filters that take a value, like 'assignee <user>' ('assignee:user' in
+ * the TUI filter bar), and what the value is, for the error messages
+ */
+var issueFilterOptions = map[string]string{
+	"labels":        "Label list",
+	"label":         "Label list",
+	"assignee":      "Assignee",
+	"creator":       "Creator",
+	"author":        "Creator",
+	"mentions":      "Mentioned user",
+	"mentioned":     "Mentioned user",
+	"milestone":     "Milestone",
+	"updated-since": "Date",
+	"since":         "Date",
+	"updated-until": "Date",
+	"until":         "Date",
+	"created-since": "Date",
+	"sort":          "Sort field",
+	"direction":     "Sort direction",
+	"state":         "State",
+}
+
+/* Relative dates, like '3d' (3 days ago) or '2w' */
+var relativeDateRegex = regexp.MustCompile(`^([0-9]+)([hdw])$`)
+
+/* Parse a filter date: a day ('2018-12-31', in local time), a time
+ * ('2018-12-31T15:04:05Z') or how long ago ('12h', '3d' or '2w')
+ *
+ * If 'endOfDay' is true, a day means its end, so 'until 2018-12-31'
+ * includes that day
+ */
+func parseFilterDate(value string, endOfDay bool) time.Time {
+	if match := relativeDateRegex.FindStringSubmatch(value); match != nil {
+		n, _ := strconv.Atoi(match[1])
+		now := time.Now()
+		switch match[2] {
+		case "h":
+			return now.Add(-time.Duration(n) * time.Hour)
+		case "d":
+			return now.AddDate(0, 0, -n)
+		default:
+			return now.AddDate(0, 0, -7*n)
+		}
+	}
+
+	if day, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		if endOfDay {
+			return day.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+		return day
+	}
+
+	date, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		panic("Invalid date " + value + ". Use a day (2018-12-31), a time " +
+			"(2018-12-31T15:04:05Z) or how long ago (12h, 3d, 2w)")
+	}
+
+	return date
+}
+
+/* Set the filter option 'key' (one of issueFilterOptions) to 'value'
+ * Return false if there's no such option
+ */
+func setIssueFilterOption(filter *TIssueFilter, key, value string) bool {
+	if _, ok := issueFilterOptions[key]; !ok {
+		return false
+	}
+
+	switch key {
+	case "labels", "label":
+		// They are comma-separated values
+		labellist := make([]TIssueLabel, 0)
+		if filter.labels != nil {
+			labellist = *filter.labels
+		}
+
+		for _, l := range strings.Split(value, ",") {
+			if l = strings.Trim(l, " "); l != "" {
+				labellist = append(labellist, TIssueLabel{name: l})
+			}
+		}
+
+		filter.labels = &labellist
+	case "assignee":
+		filter.assignee = &value
+	case "creator", "author":
+		filter.creator = &value
+	case "mentions", "mentioned":
+		filter.mentioned = &value
+	case "milestone":
+		filter.milestone = &value
+	case "updated-since", "since":
+		since := parseFilterDate(value, false)
+		filter.since = &since
+	case "updated-until", "until":
+		until := parseFilterDate(value, true)
+		filter.until = &until
+	case "created-since":
+		since := parseFilterDate(value, false)
+		filter.createdSince = &since
+	case "sort":
+		value = strings.ToLower(value)
+		if value != "created" && value != "updated" && value != "comments" {
+			panic("Unknown sort field " + value + ". Use created, updated or comments")
+		}
+		filter.sortBy = value
+	case "direction":
+		switch strings.ToLower(value) {
+		case "asc":
+			filter.ascending = true
+		case "desc":
+			filter.ascending = false
+		default:
+			panic("Unknown sort direction " + value + ". Use asc or desc")
+		}
+	case "state":
+		value = strings.ToLower(value)
+		if value != "open" && value != "closed" && value != "all" {
+			panic("Unknown state " + value + ". Use open, closed or all")
+		}
+		setIssueFilterFlag(filter, value)
+	}
+
+	return true
+}
+
+/* Set the filter flag 'flag', a filter without a value, like 'closed' or
+ * 'no-assignee'. Return false if there's no such flag
+ */
+func setIssueFilterFlag(filter *TIssueFilter, flag string) bool {
+	switch flag {
+	case "open":
+		filter.getOpen, filter.getClosed = true, false
+	case "closed":
+		filter.getOpen, filter.getClosed = false, true
+	case "all":
+		filter.getOpen, filter.getClosed = true, true
+	case "no-assignee":
+		// Like the Github API, 'none' means no one
+		none := "none"
+		filter.assignee = &none
+	case "no-label", "no-labels":
+		filter.noLabels = true
+	default:
+		return false
+	}
+
+	return true
+}
+
+/* Check if 'text' mentions the user 'user', like '@user' */
+func mentionsUser(user, text string) bool {
+	re := regexp.MustCompile(`(?i)(?:^|[^\w@/])@` + regexp.QuoteMeta(user) +
+		`(?:[^\w-]|$)`)
+	return re.MatchString(text)
+}
+
+/* Check if the issue 'a' comes before 'b' in the order 'filter' asks for
+ * Newest (or most commented) first, unless it asks for ascending order
+ */
+func issueSortsBefore(a, b *TIssue, filter TIssueFilter) bool {
+	var less, equal bool
+	switch filter.sortBy {
+	case "updated":
+		less, equal = a.updated.Before(b.updated), a.updated.Equal(b.updated)
+	case "comments":
+		less, equal = a.commentCount < b.commentCount, a.commentCount == b.commentCount
+	default:
+		less, equal = a.number < b.number, a.number == b.number
+	}
+
+	// Ties go newest first, like the hosts do
+	if equal {
+		return a.number > b.number
+	}
+
+	return less == filter.ascending
+}
+
+/* Sort 'issues' the way 'filter' asks for */
+func sortIssues(issues []TIssue, filter TIssueFilter) {
+	sort.SliceStable(issues, func(i, j int) bool {
+		return issueSortsBefore(&issues[i], &issues[j], filter)
+	})
+}
+
+/* Keep only the issues in 'issues' that match 'filter', for what a host
+ * can't filter itself, and cut them to the filter limit
+ */
+func filterIssues(issues []TIssue, filter TIssueFilter) []TIssue {
+	filtered := make([]TIssue, 0, len(issues))
+	for idx := range issues {
+		if issueMatchesFilter(&issues[idx], filter) {
+			filtered = append(filtered, issues[idx])
+		}
+	}
+
+	if filter.limit > 0 && uint(len(filtered)) > filter.limit {
+		filtered = filtered[:filter.limit]
+	}
+
+	return filtered
+}
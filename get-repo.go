@@ -11,6 +11,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"os"
 	"os/exec"
 	"regexp"
@@ -41,6 +43,22 @@ func getGitProperty(name string) (string, error) {
 	return string(bout[:len(bout)-1]), nil
 }
 
+/*
+ * Get a property from git configuration that can be set per URL, like
+ * 'http.<url>.sslCAInfo'. The most specific one matching 'url' wins, and
+ * the plain one (like 'http.sslCAInfo') is used if no URL matches
+ */
+func getGitURLProperty(name, url string) (string, error) {
+	bout, err := exec.Command("git", "config", "--get-urlmatch",
+		name, url).Output()
+
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(bout), "\n"), nil
+}
+
 /* Get the repository from the directory 'dir' */
 func getRepository(dir string) (*TRepository, error) {
 
@@ -107,15 +125,12 @@ func getRepository(dir string) (*TRepository, error) {
 	return nil, &errRepoLimit{"This git repository doesn't have a remote"}
 }
 
-/* Gets the correct repository host, based in the remote data
- * 'auth' is an authentication object, for the cases we need to authenticate
- * to even see the repository (e.g private repos)
+/* Get the repository in the current directory
+ * Only looks at the local git repository, so it doesn't need the network
  *
- * Panics if you can't get it, but it doesn't matter. You wouldn't be able to do
- * nothing if it didn't fail...
+ * Panics if you can't get it
  */
-func getRepositoryHost(auth *TAuthentication) TRepoHost {
-	/* Get an repository */
+func getCurrentRepository() *TRepository {
 	cwd, err := os.Getwd()
 	if err != nil {
 		panic("Error while getcwd()ing: " + err.Error() + "\n")
@@ -126,30 +141,74 @@ func getRepositoryHost(auth *TAuthentication) TRepoHost {
 		panic("Error while getting the repository: " + err.Error() + "\n")
 	}
 
+	return repo
+}
+
+/* Get the path of the '.git' directory of the repository we are in */
+func getGitDir() (string, error) {
+	bout, err := exec.Command("git", "rev-parse", "--absolute-git-dir").Output()
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(bout), "\n"), nil
+}
+
+/* Gets the correct repository host, based in the remote data
+ * 'ctx' cancels the host detection, and is also what the host will use
+ * for the requests made while detecting it.
+ * 'auth' is an authentication object, for the cases we need to authenticate
+ * to even see the repository (e.g private repos)
+ *
+ * Return an error if we can't find it
+ */
+func findRepositoryHost(ctx context.Context, auth *TAuthentication) (TRepoHost, error) {
+	repo := getCurrentRepository()
+
+	// Now that we know where the repository is, we know which of the
+	// per-URL http settings of git apply to it
+	if err := configureHTTPClient("https://" + repo.base_url); err != nil {
+		return nil, errors.New("Error while setting up the connection: " +
+			err.Error())
+	}
+
 	/* Try getting info on github.
 	 * TODO: bitbucket...
 	 */
 	gr := new(TGitHubRepo)
-	_, err = gr.Initialize(auth, repo)
+	_, err := gr.Initialize(ctx, auth, repo)
 	if err == nil {
-		return gr
+		return gr, nil
 	}
 
 	// This is an authentication/permission error, not an
 	// 'repo doesn't exist error
 	if ec, ok := err.(*RepoConnectError); ok {
 		if ec.ErrorCode == 401 || ec.ErrorCode == 403 {
-			panic(err)
+			return nil, err
 		}
 	}
 
 	// Try getting information on gitlab
 	lr := new(TGitLabRepo)
-	_, err = lr.Initialize(auth, repo)
+	_, err = lr.Initialize(ctx, auth, repo)
 	if err == nil {
-		return lr
+		return lr, nil
 	}
 
-	panic(err)
+	return nil, err
+}
+
+/* Gets the correct repository host, like findRepositoryHost()
+ *
+ * Panics if you can't get it, but it doesn't matter. You wouldn't be able to do
+ * nothing if it didn't fail...
+ */
+func getRepositoryHost(ctx context.Context, auth *TAuthentication) TRepoHost {
+	r, err := findRepositoryHost(ctx, auth)
+	if err != nil {
+		panic(err)
+	}
 
+	return r
 }
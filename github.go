@@ -1,9 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -34,15 +38,29 @@ type TGitHubRepo struct {
 	Description string
 
 	Issues_url        string
+	Labels_url        string
+	Milestones_url    string
 	Issue_comment_url string
 
 	Has_issues bool
 }
 
 type TGitHubIssueLabel struct {
-	ID    uint
-	Name  string
-	Color string
+	ID          uint
+	Name        string
+	Color       string
+	Description string
+}
+
+type TGitHubMilestone struct {
+	Number        uint
+	Title         string
+	Description   string
+	State         string
+	Due_on        *time.Time
+	Open_issues   int
+	Closed_issues int
+	Html_url      string
 }
 
 type TGitHubIssue struct {
@@ -54,8 +72,49 @@ type TGitHubIssue struct {
 	Html_url   string
 	State      string
 	Created_at time.Time
+	Updated_at time.Time
 	Body       string
 	Labels     []TGitHubIssueLabel
+	Milestone  *TGitHubMilestone
+	Comments   int
+
+	Pull_request *struct{} // Only in pull requests
+}
+
+/* Convert the issue Github sent to our issue format */
+func (ghissue *TGitHubIssue) toIssue() TIssue {
+	var issue TIssue
+
+	issue.id = ghissue.ID
+	issue.number = ghissue.Number
+	issue.name = ghissue.Title
+	issue.url = ghissue.Html_url
+	issue.author = ghissue.User.Login
+
+	assignees := make([]string, 0)
+	for _, assignee := range ghissue.Assignees {
+		assignees = append(assignees, assignee.Login)
+	}
+	issue.assignees = assignees
+
+	labels := make([]TIssueLabel, 0)
+	for _, ghlabel := range ghissue.Labels {
+		labels = append(labels, newIssueLabel(ghlabel.Name, ghlabel.Color))
+	}
+	issue.labels = labels
+
+	if ghissue.Milestone != nil {
+		issue.milestone = ghissue.Milestone.Title
+	}
+
+	issue.creation = ghissue.Created_at
+	issue.updated = ghissue.Updated_at
+	issue.content = ghissue.Body
+	issue.is_closed = (ghissue.State == "closed")
+	issue.commentCount = ghissue.Comments
+	issue.isPullRequest = ghissue.Pull_request != nil
+
+	return issue
 }
 
 type TGitHubIssueComment struct {
@@ -66,7 +125,7 @@ type TGitHubIssueComment struct {
 	Created_at time.Time
 }
 
-func (gh *TGitHubRepo) Initialize(auth *TAuthentication, repo *TRepository) (string, error) {
+func (gh *TGitHubRepo) Initialize(ctx context.Context, auth *TAuthentication, repo *TRepository) (string, error) {
 
 	// We need to get the api URL from the repository URL
 	// URL is https://github.com/arthurmco/clinancial
@@ -78,7 +137,7 @@ func (gh *TGitHubRepo) Initialize(auth *TAuthentication, repo *TRepository) (str
 	api_url := "https://api.github.com/repos/" + username + "/" + reponame
 
 	// Now we need to download this.
-	resp, err := gh.buildGetRequest(api_url, auth, "")
+	resp, err := gh.buildGetRequest(ctx, api_url, auth, "")
 
 	if err != nil {
 		return "", err
@@ -115,82 +174,193 @@ func (gh *TGitHubRepo) Initialize(auth *TAuthentication, repo *TRepository) (str
  * 'params' is an "HTTP GET"-like parameter string, without the '?'
  * Return the response object on success, or an error.
  */
-func (gh *TGitHubRepo) buildGetRequest(url string, auth *TAuthentication, params string) (*http.Response, error) {
-
-	client := http.Client{}
+func (gh *TGitHubRepo) buildGetRequest(ctx context.Context, url string, auth *TAuthentication, params string) (*http.Response, error) {
+	return gh.sendGetRequest(ctx, buildGitHubURL(url, params), auth)
+}
 
+/* Build the full URL of an API request
+ * 'params' is an "HTTP GET"-like parameter string, without the '?'
+ */
+func buildGitHubURL(url, params string) string {
 	// Replace spaces with HTTP-allowed spaces and + with HTTP-blessed ones
 	params = strings.Replace(params, " ", "%20", -1)
 	params = strings.Replace(params, "%", "%2B", -1)
 
-	// Build the request, and then do it
-	req, err := http.NewRequest("GET", url+"?per_page=100&"+params, nil)
-	if err != nil {
-		return nil, err
-	}
+	return url + "?per_page=100&" + params
+}
 
-	// Only send authorization data when we have an username
-	if auth != nil && auth.username != "" {
-		req.SetBasicAuth(auth.username, auth.password)
-	}
+/* Send a GET request to an already built URL, like the ones the API gives
+ * us in the 'Link' header.
+ * Return the response object on success, or an error.
+ */
+func (gh *TGitHubRepo) sendGetRequest(ctx context.Context, url string, auth *TAuthentication) (*http.Response, error) {
+	return gh.sendRequest(ctx, "GET", url, auth, nil)
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
+/* Send a request to an already built URL, with 'body' as the request body,
+ * encoded in JSON. Send nil for no body.
+ * The request is cancelled when 'ctx' is.
+ *
+ * If Github tells us to slow down (the 'secondary' rate limit, that
+ * comes with a Retry-After header), wait what it asked and try again.
+ * Return the response object on success, or an error.
+ */
+func (gh *TGitHubRepo) sendRequest(ctx context.Context, method, url string,
+	auth *TAuthentication, body interface{}) (*http.Response, error) {
+	return gh.sendRequestAccepting(ctx, method, url, "", auth, body)
+}
 
-	if resp.StatusCode == 401 {
-		return nil, &RepoConnectError{"Authentication failed: wrong username and/or password", 401}
+/* Same as sendRequest(), but asking for the media type 'accept'
+ * Github needs it for the APIs that are still in preview
+ */
+func (gh *TGitHubRepo) sendRequestAccepting(ctx context.Context, method, url,
+	accept string, auth *TAuthentication, body interface{}) (*http.Response, error) {
+
+	var jsonbody []byte
+	if body != nil {
+		var err error
+		if jsonbody, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
 	}
 
-	return resp, nil
+	for retries := 0; ; retries++ {
+		// Build the request, and then do it
+		req, err := http.NewRequest(method, url, bytes.NewReader(jsonbody))
+		if err != nil {
+			return nil, err
+		}
+		req = req.WithContext(ctx)
+
+		if jsonbody != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+
+		// Only send authorization data when we have an username
+		if auth != nil && auth.username != "" {
+			req.SetBasicAuth(auth.username, auth.password)
+		}
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode == 401 {
+			resp.Body.Close()
+			return nil, &RepoConnectError{"Authentication failed: wrong username and/or password", 401}
+		}
+
+		wait, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+		if err != nil || retries >= 3 ||
+			(resp.StatusCode != 403 && resp.StatusCode != 429) {
+			return resp, nil
+		}
+
+		resp.Body.Close()
+		select {
+		case <-time.After(time.Duration(wait) * time.Second):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
 }
 
-/* Download a range of issues based on a certain filter, return the amount of what are really issues
- * Because Github API doesn't differentiate issues from pull requests, this is needed
+/* Parse the 'Link' header the Github API sends on paginated responses
+ * It looks like this:
  *
- * It fills the 'issuelist' with the found issues
+ *   <https://api.github.com/...&page=2>; rel="next", <https://api.github.com/...&page=5>; rel="last"
  *
- * The maximum allowed by the API is 100, and it downloads 100 by 199, so count+start needs to be less
- * than 100.
+ * Return a map of the 'rel' value to the URL, like "next" => "https://..."
  */
-func (gh *TGitHubRepo) downloadIssueRange(start, count, page int,
-	auth *TAuthentication, url, params string,
-	issuelist *[]TGitHubIssue) (int, error) {
+func parseLinkHeader(link string) map[string]string {
+	links := make(map[string]string)
 
-	params = params + "&page=" + strconv.Itoa(page)
+	for _, part := range strings.Split(link, ",") {
+		fields := strings.Split(part, ";")
+		if len(fields) < 2 {
+			continue
+		}
+
+		url := strings.Trim(fields[0], " <>")
+		for _, attr := range fields[1:] {
+			attr = strings.TrimSpace(attr)
+			if strings.HasPrefix(attr, "rel=") {
+				rel := strings.Trim(attr[len("rel="):], "\"")
+				links[rel] = url
+			}
+		}
+	}
 
-	resp, err := gh.buildGetRequest(url, auth, params)
+	return links
+}
 
+/* Get the number of the page an URL from the 'Link' header points to
+ * Return 0 if the URL has no page number
+ */
+func githubPageNumber(pageurl string) int {
+	u, err := url.Parse(pageurl)
 	if err != nil {
-		return 0, err
+		return 0
+	}
+
+	page, _ := strconv.Atoi(u.Query().Get("page"))
+	return page
+}
+
+/* Make an URL from the 'Link' header point to another page */
+func githubPageURL(pageurl string, page int) string {
+	u, err := url.Parse(pageurl)
+	if err != nil {
+		return pageurl
+	}
+
+	q := u.Query()
+	q.Set("page", strconv.Itoa(page))
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+/* Download a page of issues from 'url', a fully built URL
+ *
+ * Return the issues on that page and the response headers, so you know
+ * where the next page is and how much of the rate limit is left
+ */
+func (gh *TGitHubRepo) downloadIssuePage(ctx context.Context, url string, auth *TAuthentication) ([]TGitHubIssue, http.Header, error) {
+	resp, err := gh.sendGetRequest(ctx, url, auth)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	// Read the result and build the JSON
 	defer resp.Body.Close()
+
+	if resp.StatusCode == 403 && resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		return nil, nil, &RepoConnectError{"Github API rate limit exceeded", 403}
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, nil, &RepoConnectError{"Could not download issues: " + resp.Status,
+			resp.StatusCode}
+	}
+
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return 0, err
+		return nil, nil, err
 	}
 
 	var ghissues []TGitHubIssue
 	err = json.Unmarshal(body, &ghissues)
-
-	if len(ghissues) < start {
-		// No issues to return
-		return 0, nil
+	if err != nil {
+		return nil, nil, err
 	}
 
 	// TODO: Differentiate issues from pull requests
 
-	count = len(ghissues)
-	icount := 0
-	for _, iss := range ghissues[start:(count - start)] {
-		icount += 1
-		*issuelist = append(*issuelist, iss)
-	}
-
-	return icount, nil
+	return ghissues, resp.Header, nil
 }
 
 /*
@@ -203,13 +373,18 @@ func (gh *TGitHubRepo) downloadIssueRange(start, count, page int,
  * Return an issue list on success, or nil on issue list and an error
  * on error
  */
-func (gh *TGitHubRepo) DownloadAllIssues(auth *TAuthentication, filter TIssueFilter) ([]TIssue, error) {
+func (gh *TGitHubRepo) DownloadAllIssues(ctx context.Context, auth *TAuthentication, filter TIssueFilter) ([]TIssue, error) {
 	if !gh.Has_issues {
 		// Return a nil list, since this repository doesn't has issues
 		// Return no errors too, since no error has been found
 		return nil, nil
 	}
 
+	// The issue listing can't search text, only the search API can
+	if filter.search != nil {
+		return gh.searchIssues(ctx, auth, filter)
+	}
+
 	issue_url := strings.Replace(gh.Issues_url, "{/number}", "", 1)
 
 	// Build filters
@@ -243,74 +418,264 @@ func (gh *TGitHubRepo) DownloadAllIssues(auth *TAuthentication, filter TIssueFil
 		paramstr = append(paramstr, "creator="+*filter.creator)
 	}
 
+	if filter.since != nil {
+		paramstr = append(paramstr, "since="+
+			filter.since.UTC().Format(time.RFC3339))
+	}
+
+	if filter.mentioned != nil {
+		paramstr = append(paramstr, "mentioned="+*filter.mentioned)
+	}
+
+	if filter.sortBy != "" {
+		paramstr = append(paramstr, "sort="+filter.sortBy)
+	}
+
+	if filter.ascending {
+		paramstr = append(paramstr, "direction=asc")
+	}
+
+	// The listing can't filter by these, so we do it after downloading
+	// everything
+	local := TIssueFilter{getOpen: true, getClosed: true, until: filter.until,
+		createdSince: filter.createdSince, noLabels: filter.noLabels,
+		limit: filter.limit}
+	if local.until != nil || local.createdSince != nil || local.noLabels {
+		filter.limit = 0
+	}
+
+	if filter.milestone != nil {
+		// Github wants the milestone number, not its title
+		switch strings.ToLower(*filter.milestone) {
+		case "none":
+			paramstr = append(paramstr, "milestone=none")
+		case "any":
+			paramstr = append(paramstr, "milestone=*")
+		default:
+			milestones, err := gh.DownloadMilestones(ctx, auth, "all", false)
+			if err != nil {
+				return nil, err
+			}
+
+			milestone := findMilestone(milestones, *filter.milestone)
+			if milestone == nil {
+				// No milestone, so no issues in it
+				return make([]TIssue, 0), nil
+			}
+			paramstr = append(paramstr, "milestone="+
+				strconv.Itoa(int(milestone.id)))
+		}
+	}
+
 	var ghissues []TGitHubIssue
-	imin, imax := 0, 1000
-	icurr := imin
-	ioff := icurr
-	pagen := 1
-	const pagecount = 100
+	pageurl := buildGitHubURL(issue_url, strings.Join(paramstr, "&"))
 
-	params := strings.Join(paramstr, "&")
+	pageissues, header, err := gh.downloadIssuePage(ctx,
+		pageurl, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	ghissues = append(ghissues, pageissues...)
+	links := parseLinkHeader(header.Get("Link"))
+
+	// If Github told us where the last page is, we know how many pages
+	// there are, and can download all of them at the same time
+	if lastpage := githubPageNumber(links["last"]); lastpage > 1 {
+		lastpage = pagesNeeded(filter.limit, 100, lastpage)
+
+		// Don't even start if we know we'll hit the rate limit in the
+		// middle of the way
+		remaining, err := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+		if err == nil && remaining < lastpage-1 {
+			return nil, &RepoConnectError{"Github API rate limit would be " +
+				"exceeded. Try again later or use --limit", 403}
+		}
 
-	for icurr < imax {
-		var pageissues []TGitHubIssue
-		iret, err := gh.downloadIssueRange(ioff, pagecount, pagen, auth,
-			issue_url, params, &pageissues)
+		pages := make([][]TGitHubIssue, lastpage+1)
+		err = fetchPages(ctx, 2, lastpage, func(ctx context.Context, page int) error {
+			pageissues, _, err := gh.downloadIssuePage(ctx,
+				githubPageURL(links["last"], page), auth)
+			pages[page] = pageissues
+			return err
+		})
 
 		if err != nil {
 			return nil, err
 		}
 
-		// No pages
-		if iret == 0 {
-			break
+		for _, pageissues := range pages[2:] {
+			ghissues = append(ghissues, pageissues...)
 		}
 
-		ghissues = append(ghissues, pageissues...)
+		links = nil
+	}
 
-		// Didn't reached the page count
-		if iret < pagecount {
-			break
+	// If not, follow the 'next' links until there are no more pages, or
+	// until we have all the issues the user asked for
+	for links["next"] != "" &&
+		(filter.limit == 0 || uint(len(ghissues)) < filter.limit) {
+
+		pageissues, header, err := gh.downloadIssuePage(ctx,
+			links["next"], auth)
+		if err != nil {
+			return nil, err
 		}
 
-		pagen += 1
-		icurr += pagecount
-		ioff = 0
+		ghissues = append(ghissues, pageissues...)
+		links = parseLinkHeader(header.Get("Link"))
+	}
+
+	if filter.limit > 0 && uint(len(ghissues)) > filter.limit {
+		ghissues = ghissues[:filter.limit]
 	}
 
 	issues := make([]TIssue, len(ghissues))
 
 	for idx, ghissue := range ghissues {
-		issues[idx].id = ghissue.ID
-		issues[idx].number = ghissue.Number
-		issues[idx].name = ghissue.Title
-		issues[idx].url = ghissue.Html_url
-		issues[idx].author = ghissue.User.Login
-
-		assignees := make([]string, 0)
-		for _, assignee := range ghissue.Assignees {
-			assignees = append(assignees, assignee.Login)
-		}
-		issues[idx].assignees = assignees
-
-		labels := make([]TIssueLabel, 0)
-		for _, ghlabel := range ghissue.Labels {
-			colorR, _ := strconv.ParseUint(ghlabel.Color[0:2], 16, 8)
-			colorG, _ := strconv.ParseUint(ghlabel.Color[2:4], 16, 8)
-			colorB, _ := strconv.ParseUint(ghlabel.Color[4:6], 16, 8)
-
-			labels = append(labels, TIssueLabel{
-				name:   ghlabel.Name,
-				colorR: uint8(colorR),
-				colorG: uint8(colorG),
-				colorB: uint8(colorB),
-			})
+		issues[idx] = ghissue.toIssue()
+	}
+
+	return filterIssues(issues, local), nil
+}
+
+/* Search the issues of this repository with the search API
+ * The search API doesn't have parameters for the filters, they are
+ * qualifiers in the search query, like 'label:bug'
+ *
+ * The search API only returns the first 1000 results
+ */
+func (gh *TGitHubRepo) searchIssues(ctx context.Context, auth *TAuthentication, filter TIssueFilter) ([]TIssue, error) {
+	query := []string{*filter.search, "repo:" + gh.Full_name}
+
+	if filter.labels != nil {
+		for _, l := range *filter.labels {
+			query = append(query, "label:\""+l.name+"\"")
+		}
+	}
+
+	if filter.assignee != nil {
+		switch *filter.assignee {
+		case "none":
+			query = append(query, "no:assignee")
+		case "*":
+			// There's no qualifier for it, so we filter the results below
+		default:
+			query = append(query, "assignee:"+*filter.assignee)
+		}
+	}
+
+	if filter.getOpen && !filter.getClosed {
+		query = append(query, "is:open")
+	} else if !filter.getOpen && filter.getClosed {
+		query = append(query, "is:closed")
+	}
+
+	if filter.creator != nil {
+		query = append(query, "author:"+*filter.creator)
+	}
+
+	if filter.since != nil {
+		query = append(query, "updated:>="+
+			filter.since.UTC().Format(time.RFC3339))
+	}
+
+	if filter.until != nil {
+		query = append(query, "updated:<="+
+			filter.until.UTC().Format(time.RFC3339))
+	}
+
+	if filter.createdSince != nil {
+		query = append(query, "created:>="+
+			filter.createdSince.UTC().Format(time.RFC3339))
+	}
+
+	if filter.mentioned != nil {
+		query = append(query, "mentions:"+*filter.mentioned)
+	}
+
+	if filter.noLabels {
+		query = append(query, "no:label")
+	}
+
+	if filter.milestone != nil {
+		switch strings.ToLower(*filter.milestone) {
+		case "none":
+			query = append(query, "no:milestone")
+		case "any":
+			// There's no qualifier for it, so we filter the results below
+		default:
+			query = append(query, "milestone:\""+*filter.milestone+"\"")
+		}
+	}
+
+	var ghissues []TGitHubIssue
+	pageurl := "https://api.github.com/search/issues?per_page=100&q=" +
+		url.QueryEscape(strings.Join(query, " "))
+
+	// Without a sort, the best matches come first
+	if filter.sortBy != "" || filter.ascending {
+		sortBy, order := filter.sortBy, "desc"
+		if sortBy == "" {
+			sortBy = "created"
+		}
+		if filter.ascending {
+			order = "asc"
+		}
+		pageurl += "&sort=" + sortBy + "&order=" + order
+	}
+
+	for pageurl != "" &&
+		(filter.limit == 0 || uint(len(ghissues)) < filter.limit) {
+
+		resp, err := gh.sendGetRequest(ctx, pageurl, auth)
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode == 403 {
+			return nil, &RepoConnectError{"Github search API rate limit exceeded", 403}
+		}
+
+		if resp.StatusCode != 200 {
+			return nil, &RepoConnectError{"Could not search issues: " + resp.Status,
+				resp.StatusCode}
 		}
-		issues[idx].labels = labels
 
-		issues[idx].creation = ghissue.Created_at
-		issues[idx].content = ghissue.Body
-		issues[idx].is_closed = (ghissue.State == "closed")
+		var result struct {
+			Items []TGitHubIssue
+		}
+		if err := json.Unmarshal(body, &result); err != nil {
+			return nil, err
+		}
+
+		for _, ghissue := range result.Items {
+			if filter.milestone != nil && ghissue.Milestone == nil &&
+				strings.EqualFold(*filter.milestone, "any") {
+				continue
+			}
+			if filter.assignee != nil && *filter.assignee == "*" &&
+				len(ghissue.Assignees) == 0 {
+				continue
+			}
+			ghissues = append(ghissues, ghissue)
+		}
+		pageurl = parseLinkHeader(resp.Header.Get("Link"))["next"]
+	}
+
+	if filter.limit > 0 && uint(len(ghissues)) > filter.limit {
+		ghissues = ghissues[:filter.limit]
+	}
+
+	issues := make([]TIssue, len(ghissues))
+	for idx, ghissue := range ghissues {
+		issues[idx] = ghissue.toIssue()
 	}
 
 	return issues, nil
@@ -319,7 +684,7 @@ func (gh *TGitHubRepo) DownloadAllIssues(auth *TAuthentication, filter TIssueFil
 /*
  * Download an specific issue from this github repository
  */
-func (gh *TGitHubRepo) DownloadIssue(auth *TAuthentication, id uint) (*TIssue, error) {
+func (gh *TGitHubRepo) DownloadIssue(ctx context.Context, auth *TAuthentication, id uint) (*TIssue, error) {
 	if !gh.Has_issues {
 		// Return a nil list, since this repository doesn't has issues
 		// Return no errors too, since no error has been found
@@ -329,7 +694,7 @@ func (gh *TGitHubRepo) DownloadIssue(auth *TAuthentication, id uint) (*TIssue, e
 	issue_url := strings.Replace(gh.Issues_url, "{/number}",
 		"/"+strconv.Itoa(int(id)), 1)
 
-	resp, err := gh.buildGetRequest(issue_url, auth, "")
+	resp, err := gh.buildGetRequest(ctx, issue_url, auth, "")
 	if err != nil {
 		return nil, err
 	}
@@ -350,44 +715,12 @@ func (gh *TGitHubRepo) DownloadIssue(auth *TAuthentication, id uint) (*TIssue, e
 		return nil, err
 	}
 
-	issue := new(TIssue)
-
-	issue.id = ghissue.ID
-	issue.number = ghissue.Number
-	issue.name = ghissue.Title
-	issue.url = ghissue.Html_url
-	issue.author = ghissue.User.Login
-
-	assignees := make([]string, 0)
-	for _, assignee := range ghissue.Assignees {
-		assignees = append(assignees, assignee.Login)
-	}
-	issue.assignees = assignees
-
-	labels := make([]TIssueLabel, 0)
-	for _, ghlabel := range ghissue.Labels {
-		colorR, _ := strconv.ParseUint(ghlabel.Color[0:2], 16, 8)
-		colorG, _ := strconv.ParseUint(ghlabel.Color[2:4], 16, 8)
-		colorB, _ := strconv.ParseUint(ghlabel.Color[4:6], 16, 8)
-
-		labels = append(labels, TIssueLabel{
-			name:   ghlabel.Name,
-			colorR: uint8(colorR),
-			colorG: uint8(colorG),
-			colorB: uint8(colorB),
-		})
-	}
-	issue.labels = labels
-
-	issue.creation = ghissue.Created_at
-	issue.content = ghissue.Body
-	issue.is_closed = (ghissue.State == "closed")
-
-	return issue, nil
+	issue := ghissue.toIssue()
+	return &issue, nil
 }
 
 /* Download all comments from that issue */
-func (gh *TGitHubRepo) DownloadIssueComments(auth *TAuthentication, issue_id uint) ([]TIssueComment, error) {
+func (gh *TGitHubRepo) DownloadIssueComments(ctx context.Context, auth *TAuthentication, issue_id uint) ([]TIssueComment, error) {
 
 	if !gh.Has_issues {
 		// Return a nil list, since this repository doesn't has issues
@@ -398,25 +731,36 @@ func (gh *TGitHubRepo) DownloadIssueComments(auth *TAuthentication, issue_id uin
 	comment_url := strings.Replace(gh.Issues_url, "{/number}",
 		"/"+strconv.Itoa(int(issue_id))+"/comments", 1)
 
-	resp, err := gh.buildGetRequest(comment_url, auth, "")
-	if err != nil {
-		return nil, err
-	}
+	// Follow the pages in the Link header, like with the milestones
+	var ghcomments []TGitHubIssueComment
+	pageurl := buildGitHubURL(comment_url, "")
+	for pageurl != "" {
+		resp, err := gh.sendGetRequest(ctx, pageurl, auth)
+		if err != nil {
+			return nil, err
+		}
 
-	/* No issue comments. This isn't an error, only means that this issue
-	 * doesn't have comments
-	 */
-	if resp.StatusCode == 404 {
-		return nil, nil
-	}
+		/* No issue comments. This isn't an error, only means that this
+		 * issue doesn't have comments
+		 */
+		if resp.StatusCode == 404 {
+			resp.Body.Close()
+			return nil, nil
+		}
 
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
 
-	var ghcomments []TGitHubIssueComment
-	err = json.Unmarshal(body, &ghcomments)
-	if err != nil {
-		return nil, err
+		var pagecomments []TGitHubIssueComment
+		if err := json.Unmarshal(body, &pagecomments); err != nil {
+			return nil, err
+		}
+		ghcomments = append(ghcomments, pagecomments...)
+
+		pageurl = parseLinkHeader(resp.Header.Get("Link"))["next"]
 	}
 
 	comments := make([]TIssueComment, len(ghcomments))
@@ -431,3 +775,559 @@ func (gh *TGitHubRepo) DownloadIssueComments(auth *TAuthentication, issue_id uin
 
 	return comments, nil
 }
+
+/* Get the API URL of the issue 'issue_id', followed by 'suffix'
+ * Send 0 as the issue to get the URL of the issue list
+ */
+func (gh *TGitHubRepo) issueURL(issue_id uint, suffix string) string {
+	number := ""
+	if issue_id > 0 {
+		number = "/" + strconv.Itoa(int(issue_id))
+	}
+
+	return strings.Replace(gh.Issues_url, "{/number}", number, 1) + suffix
+}
+
+/* Send a request that changes something, with 'body' encoded in JSON, and
+ * decode the answer into 'result' (send nil if you don't care about it)
+ *
+ * Return an error if Github didn't like what we sent
+ */
+func (gh *TGitHubRepo) sendJSONRequest(ctx context.Context, method, url string,
+	auth *TAuthentication, body, result interface{}) error {
+
+	resp, err := gh.sendRequest(ctx, method, url, auth, body)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	rbody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		// Github sends the reason in a 'message' field
+		var ghError struct {
+			Message string
+		}
+		_ = json.Unmarshal(rbody, &ghError)
+
+		msg := resp.Status
+		if ghError.Message != "" {
+			msg = ghError.Message
+		}
+
+		// Github answers 404 when we aren't allowed to see or change
+		// something, so we don't even know it exists
+		if resp.StatusCode == 404 && (auth == nil || auth.username == "") {
+			msg += " (maybe you need to specify your username?)"
+		}
+
+		return &RepoConnectError{msg, resp.StatusCode}
+	}
+
+	if result == nil || len(rbody) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(rbody, result)
+}
+
+/* Create an issue with the title 'title', the content 'content' and the
+ * labels 'labels'. Return the created issue
+ */
+func (gh *TGitHubRepo) CreateIssue(ctx context.Context, auth *TAuthentication,
+	title, content string, labels []string) (*TIssue, error) {
+
+	body := map[string]interface{}{
+		"title":  title,
+		"body":   content,
+		"labels": labels,
+	}
+
+	var ghissue TGitHubIssue
+	err := gh.sendJSONRequest(ctx, "POST", gh.issueURL(0, ""), auth,
+		body, &ghissue)
+	if err != nil {
+		return nil, err
+	}
+
+	issue := ghissue.toIssue()
+	return &issue, nil
+}
+
+/* Add a comment to the issue 'issue_id'. Return the created comment */
+func (gh *TGitHubRepo) CreateIssueComment(ctx context.Context, auth *TAuthentication,
+	issue_id uint, content string) (*TIssueComment, error) {
+
+	var ghcomment TGitHubIssueComment
+	err := gh.sendJSONRequest(ctx, "POST", gh.issueURL(issue_id, "/comments"),
+		auth, map[string]string{"body": content}, &ghcomment)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TIssueComment{
+		id:       ghcomment.ID,
+		url:      ghcomment.Html_url,
+		author:   ghcomment.User.Login,
+		creation: ghcomment.Created_at,
+		content:  ghcomment.Body,
+	}, nil
+}
+
+/* Close the issue 'issue_id', or reopen it if 'closed' is false */
+func (gh *TGitHubRepo) SetIssueState(ctx context.Context, auth *TAuthentication,
+	issue_id uint, closed bool) error {
+
+	state := "open"
+	if closed {
+		state = "closed"
+	}
+
+	return gh.sendJSONRequest(ctx, "PATCH", gh.issueURL(issue_id, ""), auth,
+		map[string]string{"state": state}, nil)
+}
+
+/* Add the labels 'add' and remove the labels 'remove' from the issue
+ * 'issue_id'
+ */
+func (gh *TGitHubRepo) EditIssueLabels(ctx context.Context, auth *TAuthentication,
+	issue_id uint, add, remove []string) error {
+
+	if len(add) > 0 {
+		err := gh.sendJSONRequest(ctx, "POST",
+			gh.issueURL(issue_id, "/labels"), auth,
+			map[string][]string{"labels": add}, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, label := range remove {
+		err := gh.sendJSONRequest(ctx, "DELETE",
+			gh.issueURL(issue_id, "/labels/"+url.PathEscape(label)),
+			auth, nil, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/* Add the users 'assignees' to the assignees of the issue 'issue_id'
+ * Send no users to assign the authenticated user
+ */
+func (gh *TGitHubRepo) AssignIssue(ctx context.Context, auth *TAuthentication,
+	issue_id uint, assignees []string) error {
+
+	if len(assignees) == 0 {
+		var user TGitHubUser
+		err := gh.sendJSONRequest(ctx, "GET", "https://api.github.com/user",
+			auth, nil, &user)
+		if err != nil {
+			return err
+		}
+		assignees = []string{user.Login}
+	}
+
+	return gh.sendJSONRequest(ctx, "POST", gh.issueURL(issue_id, "/assignees"),
+		auth, map[string][]string{"assignees": assignees}, nil)
+}
+
+/* An event of the issue timeline. Only what we use of them
+ *
+ * 'cross-referenced' events come from another issue or pull request (the
+ * 'source'), and 'referenced' and 'closed' ones can come from a commit
+ */
+type TGitHubTimelineEvent struct {
+	Event      string
+	Actor      TGitHubUser
+	Created_at time.Time
+	Commit_id  string
+	Commit_url string
+
+	Label    TGitHubIssueLabel
+	Assignee TGitHubUser
+	Rename   struct {
+		From string
+		To   string
+	}
+	Milestone struct {
+		Title string
+	}
+
+	Source struct {
+		Type  string
+		Issue TGitHubReferenceIssue
+	}
+}
+
+/* An issue, or a pull request, that references another issue */
+type TGitHubReferenceIssue struct {
+	TGitHubIssue
+
+	Pull_request *struct {
+		Html_url  string
+		Merged_at *time.Time
+	}
+	Repository struct {
+		Full_name string
+	}
+}
+
+/* Download the timeline of the issue 'issue_id', with every page of it */
+func (gh *TGitHubRepo) downloadTimeline(ctx context.Context, auth *TAuthentication,
+	issue_id uint) ([]TGitHubTimelineEvent, error) {
+
+	events := make([]TGitHubTimelineEvent, 0)
+	pageurl := buildGitHubURL(gh.issueURL(issue_id, "/timeline"), "")
+	for pageurl != "" {
+		resp, err := gh.sendRequestAccepting(ctx, "GET", pageurl,
+			"application/vnd.github.mockingbird-preview+json", auth, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode == 404 {
+			return nil, nil
+		}
+
+		if resp.StatusCode != 200 {
+			return nil, &RepoConnectError{"Could not download the issue timeline: " +
+				resp.Status, resp.StatusCode}
+		}
+
+		var page []TGitHubTimelineEvent
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, err
+		}
+
+		events = append(events, page...)
+		pageurl = parseLinkHeader(resp.Header.Get("Link"))["next"]
+	}
+
+	return events, nil
+}
+
+/* Get what referenced the issue in the timeline event 'event', or nil if
+ * it's not a reference. 'closes' matches text that closes the issue
+ */
+func (gh *TGitHubRepo) timelineReference(event TGitHubTimelineEvent,
+	closes *regexp.Regexp) *TIssueReference {
+
+	switch {
+	case event.Event == "cross-referenced" && event.Source.Issue.Number > 0:
+		source := event.Source.Issue
+		ref := &TIssueReference{
+			kind:   "issue",
+			number: source.Number,
+			title:  source.Title,
+			url:    source.Html_url,
+			state:  source.State,
+			closes: closes.MatchString(source.Body),
+		}
+
+		if !strings.EqualFold(source.Repository.Full_name, gh.Full_name) {
+			ref.repo = source.Repository.Full_name
+		}
+
+		if source.Pull_request != nil {
+			ref.kind = "pull request"
+			if source.Pull_request.Merged_at != nil {
+				ref.state = "merged"
+			}
+		}
+
+		return ref
+
+	case (event.Event == "referenced" || event.Event == "closed") &&
+		event.Commit_id != "":
+		return &TIssueReference{
+			kind:   "commit",
+			id:     event.Commit_id,
+			url:    "https://github.com/" + gh.Full_name + "/commit/" + event.Commit_id,
+			closes: event.Event == "closed",
+		}
+	}
+
+	return nil
+}
+
+/* Download the pull requests and issues that mention or close the issue
+ * 'issue_id', and the commits Github knows that do
+ *
+ * They come from the cross-references in the issue timeline
+ */
+func (gh *TGitHubRepo) DownloadIssueReferences(ctx context.Context, auth *TAuthentication,
+	issue_id uint) ([]TIssueReference, error) {
+
+	if !gh.Has_issues {
+		return nil, nil
+	}
+
+	events, err := gh.downloadTimeline(ctx, auth, issue_id)
+	if err != nil {
+		return nil, err
+	}
+
+	closes := closingReferenceRegex(gh.Full_name,
+		"https://github.com/"+gh.Full_name, issue_id)
+
+	refs := make([]TIssueReference, 0)
+	for _, event := range events {
+		if ref := gh.timelineReference(event, closes); ref != nil {
+			refs = append(refs, *ref)
+		}
+	}
+
+	return mergeIssueReferences(refs, nil), nil
+}
+
+/* Timeline events that only say someone saw the issue, so they aren't
+ * worth showing
+ */
+var githubQuietEvents = []string{"commented", "mentioned", "subscribed",
+	"unsubscribed"}
+
+/* Download what happened to the issue 'issue_id', besides the comments,
+ * from its timeline
+ */
+func (gh *TGitHubRepo) DownloadIssueEvents(ctx context.Context, auth *TAuthentication,
+	issue_id uint) ([]TIssueEvent, error) {
+
+	if !gh.Has_issues {
+		return nil, nil
+	}
+
+	timeline, err := gh.downloadTimeline(ctx, auth, issue_id)
+	if err != nil {
+		return nil, err
+	}
+
+	closes := closingReferenceRegex(gh.Full_name,
+		"https://github.com/"+gh.Full_name, issue_id)
+
+	events := make([]TIssueEvent, 0, len(timeline))
+	for _, ghevent := range timeline {
+		if ghevent.Event == "" || containsFold(githubQuietEvents, ghevent.Event) {
+			continue
+		}
+
+		event := TIssueEvent{
+			kind:    ghevent.Event,
+			actor:   ghevent.Actor.Login,
+			created: ghevent.Created_at,
+			ref:     gh.timelineReference(ghevent, closes),
+		}
+
+		switch ghevent.Event {
+		case "labeled", "unlabeled":
+			event.label = newIssueLabel(ghevent.Label.Name, ghevent.Label.Color)
+		case "assigned", "unassigned":
+			event.user = ghevent.Assignee.Login
+		case "renamed":
+			event.from = ghevent.Rename.From
+			event.to = ghevent.Rename.To
+		case "milestoned", "demilestoned":
+			event.detail = ghevent.Milestone.Title
+		case "cross-referenced":
+			event.kind = "referenced"
+		}
+
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+/* Get the API URL of the label 'name'
+ * Send an empty name to get the URL of the label list
+ */
+func (gh *TGitHubRepo) labelURL(name string) string {
+	if name != "" {
+		name = "/" + url.PathEscape(name)
+	}
+
+	return strings.Replace(gh.Labels_url, "{/name}", name, 1)
+}
+
+/* Download all labels of the repository, with every page of them */
+func (gh *TGitHubRepo) DownloadLabels(ctx context.Context, auth *TAuthentication) ([]TRepoLabel, error) {
+	labels := make([]TRepoLabel, 0)
+	pageurl := buildGitHubURL(gh.labelURL(""), "")
+	for pageurl != "" {
+		resp, err := gh.sendGetRequest(ctx, pageurl, auth)
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != 200 {
+			return nil, &RepoConnectError{"Could not download the labels: " +
+				resp.Status, resp.StatusCode}
+		}
+
+		var ghlabels []TGitHubIssueLabel
+		if err := json.Unmarshal(body, &ghlabels); err != nil {
+			return nil, err
+		}
+
+		for _, ghlabel := range ghlabels {
+			labels = append(labels, TRepoLabel{
+				TIssueLabel: newIssueLabel(ghlabel.Name, ghlabel.Color),
+				description: ghlabel.Description,
+			})
+		}
+
+		pageurl = parseLinkHeader(resp.Header.Get("Link"))["next"]
+	}
+
+	return labels, nil
+}
+
+/* Create the label 'label' */
+func (gh *TGitHubRepo) CreateLabel(ctx context.Context, auth *TAuthentication,
+	label TRepoLabel) error {
+
+	return gh.sendJSONRequest(ctx, "POST", gh.labelURL(""), auth,
+		map[string]string{
+			"name":        label.name,
+			"color":       label.hexColor(),
+			"description": label.description,
+		}, nil)
+}
+
+/* Change the label named 'name' into 'label' */
+func (gh *TGitHubRepo) EditLabel(ctx context.Context, auth *TAuthentication,
+	name string, label TRepoLabel) error {
+
+	return gh.sendJSONRequest(ctx, "PATCH", gh.labelURL(name), auth,
+		map[string]string{
+			"new_name":    label.name,
+			"color":       label.hexColor(),
+			"description": label.description,
+		}, nil)
+}
+
+/* Delete the label named 'name' */
+func (gh *TGitHubRepo) DeleteLabel(ctx context.Context, auth *TAuthentication,
+	name string) error {
+
+	return gh.sendJSONRequest(ctx, "DELETE", gh.labelURL(name), auth, nil, nil)
+}
+
+/* Get the API URL of the milestone number 'number'
+ * Send 0 to get the URL of the milestone list
+ */
+func (gh *TGitHubRepo) milestoneURL(number uint) string {
+	param := ""
+	if number > 0 {
+		param = "/" + strconv.Itoa(int(number))
+	}
+
+	return strings.Replace(gh.Milestones_url, "{/number}", param, 1)
+}
+
+/* Convert a milestone we have to the fields Github wants */
+func (gh *TGitHubRepo) milestoneToGitHub(milestone TMilestone) map[string]interface{} {
+	ghmilestone := map[string]interface{}{
+		"title":       milestone.title,
+		"description": milestone.description,
+		"state":       "open",
+		"due_on":      nil,
+	}
+
+	if milestone.is_closed {
+		ghmilestone["state"] = "closed"
+	}
+
+	if !milestone.due.IsZero() {
+		ghmilestone["due_on"] = milestone.due.UTC().Format(time.RFC3339)
+	}
+
+	return ghmilestone
+}
+
+/* Download the milestones of the repository in the state 'state'
+ * Github always sends the issue counts, so 'counts' doesn't matter
+ */
+func (gh *TGitHubRepo) DownloadMilestones(ctx context.Context, auth *TAuthentication,
+	state string, counts bool) ([]TMilestone, error) {
+
+	milestones := make([]TMilestone, 0)
+	pageurl := buildGitHubURL(gh.milestoneURL(0), "state="+state+"&sort=due_on")
+	for pageurl != "" {
+		resp, err := gh.sendGetRequest(ctx, pageurl, auth)
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != 200 {
+			return nil, &RepoConnectError{"Could not download the milestones: " +
+				resp.Status, resp.StatusCode}
+		}
+
+		var ghmilestones []TGitHubMilestone
+		if err := json.Unmarshal(body, &ghmilestones); err != nil {
+			return nil, err
+		}
+
+		for _, ghmilestone := range ghmilestones {
+			milestone := TMilestone{
+				id:           ghmilestone.Number,
+				title:        ghmilestone.Title,
+				description:  ghmilestone.Description,
+				is_closed:    ghmilestone.State == "closed",
+				url:          ghmilestone.Html_url,
+				openIssues:   ghmilestone.Open_issues,
+				closedIssues: ghmilestone.Closed_issues,
+			}
+			if ghmilestone.Due_on != nil {
+				milestone.due = *ghmilestone.Due_on
+			}
+
+			milestones = append(milestones, milestone)
+		}
+
+		pageurl = parseLinkHeader(resp.Header.Get("Link"))["next"]
+	}
+
+	return milestones, nil
+}
+
+/* Create the milestone 'milestone' */
+func (gh *TGitHubRepo) CreateMilestone(ctx context.Context, auth *TAuthentication,
+	milestone TMilestone) error {
+
+	return gh.sendJSONRequest(ctx, "POST", gh.milestoneURL(0), auth,
+		gh.milestoneToGitHub(milestone), nil)
+}
+
+/* Change the milestone 'milestone.id' into 'milestone' */
+func (gh *TGitHubRepo) EditMilestone(ctx context.Context, auth *TAuthentication,
+	milestone TMilestone) error {
+
+	return gh.sendJSONRequest(ctx, "PATCH", gh.milestoneURL(milestone.id), auth,
+		gh.milestoneToGitHub(milestone), nil)
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
-
+	"context"
+	"encoding/json"
+	"fmt"
 	"github.com/xanzy/go-gitlab"
-	"math"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
 /**
@@ -26,18 +30,19 @@ type TGitLabRepo struct {
  * It needs to fill all fields of the 'repo' structure
  * Returns an error object on error
  */
-func (gl *TGitLabRepo) Initialize(auth *TAuthentication, repo *TRepository) (string, error) {
+func (gl *TGitLabRepo) Initialize(ctx context.Context, auth *TAuthentication, repo *TRepository) (string, error) {
 
 	token := ""
 	if auth != nil && auth.token != "" {
 		token = auth.token
 	}
 
-	git := gitlab.NewClient(nil, token)
+	git := gitlab.NewClient(httpClient, token)
 
 	_ = git.SetBaseURL("https://" + repo.base_url + "/api/v4/")
 
-	project, _, err := git.Projects.GetProject(repo.author + "/" + repo.name)
+	project, _, err := git.Projects.GetProject(repo.author+"/"+repo.name,
+		gitlab.WithContext(ctx))
 	if err != nil {
 		return "", err
 	}
@@ -60,53 +65,87 @@ func (gl *TGitLabRepo) Initialize(auth *TAuthentication, repo *TRepository) (str
 }
 
 /* Get a map with all labels and the hex colors used in this project */
-func (gl *TGitLabRepo) getLabels() (map[string]string, error) {
+func (gl *TGitLabRepo) getLabels(ctx context.Context) (map[string]string, error) {
 	// Get the label colors. For the visuals!
 	var labelColors = map[string]string{}
-	labels, _, err := gl.client.Labels.ListLabels(gl.project.ID, nil)
+	labels, err := gl.DownloadLabels(ctx, nil)
 
 	if err != nil {
 		return nil, err
 	}
 
 	for _, l := range labels {
-		labelColors[l.Name] = l.Color
+		labelColors[l.name] = l.hexColor()
 	}
 
 	return labelColors, nil
 }
 
-/**
- * Download an issue range based on a filter.
- *
- * This function returns, at maximum, 100 issues, because it's the gitlab API
- * limit per request.
- *
- * Fills the 'lissue' with the found issues and return the number of issues found
+/* Download all labels of the project, with every page of them */
+func (gl *TGitLabRepo) DownloadLabels(ctx context.Context, auth *TAuthentication) ([]TRepoLabel, error) {
+	labels := make([]TRepoLabel, 0)
+	options := gitlab.ListLabelsOptions{Page: 1, PerPage: 100}
+	for {
+		gllabels, _, err := gl.client.Labels.ListLabels(gl.project.ID,
+			&options, gitlab.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, l := range gllabels {
+			labels = append(labels, TRepoLabel{
+				TIssueLabel: newIssueLabel(l.Name, l.Color),
+				description: l.Description,
+			})
+		}
+
+		if len(gllabels) < options.PerPage {
+			return labels, nil
+		}
+		options.Page++
+	}
+}
+
+/* Convert the issue Gitlab sent to our issue format
+ * 'labelColors' is the label color map getLabels() returns, since Gitlab
+ * only sends us the label names
  */
-func (gl *TGitLabRepo) downloadIssueRange(start, count, page int,
-	auth *TAuthentication, goptions gitlab.ListProjectIssuesOptions,
-	lissue *[]gitlab.Issue) (int, error) {
-	goptions.Page = page
-	goptions.PerPage = 100
+func convertGitLabIssue(glissue *gitlab.Issue, labelColors map[string]string) TIssue {
+	// Usernames, not names, since the filters and 'assign' take them
+	assignees := make([]string, 0, len(glissue.Assignees))
+	for _, assignee := range glissue.Assignees {
+		assignees = append(assignees, assignee.Username)
+	}
 
-	glissues, _, err := gl.client.Issues.ListProjectIssues(gl.project.ID,
-		&goptions)
+	labels := make([]TIssueLabel, 0, len(glissue.Labels))
+	for _, label := range glissue.Labels {
+		labels = append(labels, newIssueLabel(label, labelColors[label]))
+	}
 
-	if err != nil {
-		return 0, err
+	issue := TIssue{
+		id:        uint(glissue.ID),
+		number:    uint(glissue.IID),
+		name:      glissue.Title,
+		url:       glissue.WebURL,
+		author:    glissue.Author.Username,
+		assignees: assignees,
+		labels:    labels,
+		creation:  *glissue.CreatedAt,
+		content:   glissue.Description,
+		is_closed: (glissue.State == "closed"),
 	}
 
-	if len(glissues) < start {
-		return 0, nil
+	if glissue.UpdatedAt != nil {
+		issue.updated = *glissue.UpdatedAt
 	}
 
-	count = int(math.Min(float64(count), float64(len(glissues)-start)))
-	for _, iss := range glissues[start:(count - start)] {
-		*lissue = append(*lissue, *iss)
+	if glissue.Milestone != nil {
+		issue.milestone = glissue.Milestone.Title
 	}
 
-	return count, nil
+	issue.commentCount = glissue.UserNotesCount
+
+	return issue
 }
 
 /* Download all issues from the repository
@@ -118,10 +157,15 @@ func (gl *TGitLabRepo) downloadIssueRange(start, count, page int,
  * Return an issue list on success, or nil on issue list and an error
  * on error
  */
-func (gl *TGitLabRepo) DownloadAllIssues(auth *TAuthentication, filter TIssueFilter) ([]TIssue, error) {
+func (gl *TGitLabRepo) DownloadAllIssues(ctx context.Context, auth *TAuthentication, filter TIssueFilter) ([]TIssue, error) {
 
 	var goptions gitlab.ListProjectIssuesOptions
 
+	// What Gitlab can't filter or sort by, we do after downloading
+	// everything. Gitlab doesn't tell us who the issues mention, so
+	// filterMentioned() looks for them
+	local := TIssueFilter{getOpen: true, getClosed: true, limit: filter.limit}
+
 	// Create filter
 	if filter.labels != nil {
 		glabels := make([]string, 0, len(*filter.labels))
@@ -132,6 +176,36 @@ func (gl *TGitLabRepo) DownloadAllIssues(auth *TAuthentication, filter TIssueFil
 		goptions.Labels = glabels
 	}
 
+	if filter.noLabels {
+		goptions.Labels = gitlab.Labels{"None"}
+	}
+
+	// Gitlab wants user IDs. If the user doesn't exist, no issue is theirs
+	if filter.assignee != nil {
+		switch *filter.assignee {
+		case "none", "*":
+			local.assignee = filter.assignee
+		default:
+			id, err := gl.findUserID(ctx, *filter.assignee)
+			if rerr, ok := err.(*RepoConnectError); ok && rerr.ErrorCode == 404 {
+				return make([]TIssue, 0), nil
+			} else if err != nil {
+				return nil, err
+			}
+			goptions.AssigneeID = &id
+		}
+	}
+
+	if filter.creator != nil {
+		id, err := gl.findUserID(ctx, *filter.creator)
+		if rerr, ok := err.(*RepoConnectError); ok && rerr.ErrorCode == 404 {
+			return make([]TIssue, 0), nil
+		} else if err != nil {
+			return nil, err
+		}
+		goptions.AuthorID = &id
+	}
+
 	gstate := ""
 	if filter.getOpen && !filter.getClosed {
 		gstate = "opened"
@@ -143,43 +217,128 @@ func (gl *TGitLabRepo) DownloadAllIssues(auth *TAuthentication, filter TIssueFil
 		goptions.State = &gstate
 	}
 
-	labelColors, err := gl.getLabels()
+	if filter.since != nil {
+		goptions.UpdatedAfter = filter.since
+	}
+
+	if filter.until != nil {
+		goptions.UpdatedBefore = filter.until
+	}
+
+	if filter.createdSince != nil {
+		goptions.CreatedAfter = filter.createdSince
+	}
+
+	orderBy, sortDir := "created_at", "desc"
+	if filter.sortBy == "updated" {
+		orderBy = "updated_at"
+	}
+	if filter.ascending {
+		sortDir = "asc"
+	}
+	goptions.OrderBy = &orderBy
+	goptions.Sort = &sortDir
+
+	if filter.mentioned != nil || local.assignee != nil || filter.sortBy == "comments" {
+		filter.limit = 0
+	}
+
+	if filter.search != nil {
+		goptions.Search = filter.search
+	}
+
+	if filter.milestone != nil {
+		// Gitlab wants 'None' and 'Any' capitalized
+		milestone := *filter.milestone
+		switch strings.ToLower(milestone) {
+		case "none":
+			milestone = "None"
+		case "any":
+			milestone = "Any"
+		}
+		goptions.Milestone = &milestone
+	}
+
+	labelColors, err := gl.getLabels(ctx)
 	if err != nil {
 		return nil, err
 	}
 
 	// Do the request
 	var glissues []gitlab.Issue
-	imin, imax := 0, 1000
-	icurr := imin
-	ioff := icurr
-	pagen := 1
-	const pagecount = 100
+	goptions.Page = 1
+	goptions.PerPage = 100
+
+	pageissues, resp, err := gl.client.Issues.ListProjectIssues(
+		gl.project.ID, &goptions, gitlab.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, iss := range pageissues {
+		glissues = append(glissues, *iss)
+	}
+	nextpage := resp.NextPage
+
+	// Gitlab tells us how many pages there are in X-Total-Pages, so we
+	// can download all of them at the same time.
+	// It doesn't send it for really big listings, though
+	if resp.TotalPages > 1 {
+		lastpage := pagesNeeded(filter.limit, goptions.PerPage,
+			resp.TotalPages)
+
+		// Don't even start if we know we'll hit the rate limit in the
+		// middle of the way
+		remaining, err := strconv.Atoi(resp.Header.Get("RateLimit-Remaining"))
+		if err == nil && remaining < lastpage-1 {
+			return nil, &RepoConnectError{"Gitlab API rate limit would be " +
+				"exceeded. Try again later or use --limit", 429}
+		}
+
+		pages := make([][]*gitlab.Issue, lastpage+1)
+		err = fetchPages(ctx, 2, lastpage, func(ctx context.Context, page int) error {
+			poptions := goptions
+			poptions.Page = page
 
-	for icurr < imax {
-		var pageissues []gitlab.Issue
-		iret, err := gl.downloadIssueRange(ioff, pagecount, pagen, auth,
-			goptions, &pageissues)
+			pageissues, _, err := gl.client.Issues.ListProjectIssues(
+				gl.project.ID, &poptions, gitlab.WithContext(ctx))
+			pages[page] = pageissues
+			return err
+		})
 
 		if err != nil {
 			return nil, err
 		}
 
-		// No pages
-		if iret == 0 {
-			break
+		for _, pageissues := range pages[2:] {
+			for _, iss := range pageissues {
+				glissues = append(glissues, *iss)
+			}
 		}
 
-		glissues = append(glissues, pageissues...)
+		nextpage = 0
+	}
 
-		// Didn't reached the page count
-		if iret < pagecount {
-			break
+	// If not, follow X-Next-Page until there are no more pages, or until
+	// we have all the issues the user asked for
+	for nextpage != 0 &&
+		(filter.limit == 0 || uint(len(glissues)) < filter.limit) {
+
+		goptions.Page = nextpage
+		pageissues, resp, err := gl.client.Issues.ListProjectIssues(
+			gl.project.ID, &goptions, gitlab.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, iss := range pageissues {
+			glissues = append(glissues, *iss)
 		}
+		nextpage = resp.NextPage
+	}
 
-		pagen += 1
-		icurr += pagecount
-		ioff = 0
+	if filter.limit > 0 && uint(len(glissues)) > filter.limit {
+		glissues = glissues[:filter.limit]
 	}
 
 	issues := make([]TIssue, 0, len(glissues))
@@ -188,48 +347,81 @@ func (gl *TGitLabRepo) DownloadAllIssues(auth *TAuthentication, filter TIssueFil
 		return nil, nil
 	}
 
-	for _, issue := range glissues {
+	for idx := range glissues {
+		issues = append(issues, convertGitLabIssue(&glissues[idx], labelColors))
+	}
 
-		assignees := make([]string, 0, len(issue.Assignees))
-		for _, assignee := range issue.Assignees {
-			assignees = append(assignees, assignee.Name)
+	if filter.sortBy == "comments" {
+		sortIssues(issues, filter)
+	}
+
+	if filter.mentioned == nil {
+		return filterIssues(issues, local), nil
+	}
+
+	// Only look for the mentions in the issues the rest of the filter
+	// keeps, and cut them to the limit after
+	limit := local.limit
+	local.limit = 0
+	issues, err = gl.filterMentioned(ctx, filterIssues(issues, local),
+		*filter.mentioned)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit > 0 && uint(len(issues)) > limit {
+		issues = issues[:limit]
+	}
+
+	return issues, nil
+}
+
+/* Keep only the issues in 'issues' that mention the user 'user', in the
+ * description or in a comment, like the cache does
+ *
+ * Gitlab can't filter by it, so we download the comments of the issues
+ * whose description doesn't mention the user, all at the same time
+ */
+func (gl *TGitLabRepo) filterMentioned(ctx context.Context, issues []TIssue,
+	user string) ([]TIssue, error) {
+
+	keep := make([]bool, len(issues))
+	err := fetchPages(ctx, 0, len(issues)-1, func(ctx context.Context, idx int) error {
+		issue := &issues[idx]
+		if mentionsUser(user, issue.content) {
+			keep[idx] = true
+			return nil
+		}
+
+		if issue.commentCount == 0 {
+			return nil
+		}
+
+		comments, err := gl.DownloadIssueComments(ctx, nil, issue.number)
+		if err != nil {
+			return err
 		}
 
-		labels := make([]TIssueLabel, 0, len(issue.Labels))
-		for _, label := range issue.Labels {
-			// Gitlab returned label colors have a "#" prefix,
-			// like in '#ff0000'. We need to take it out
-			lcolor := "#ffffff"
-			if labelColors[label] != "" &&
-				len(labelColors[label]) > 2 {
-				lcolor = labelColors[label][1:]
+		for _, comment := range comments {
+			if mentionsUser(user, comment.content) {
+				keep[idx] = true
+				break
 			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-			cR, _ := strconv.ParseUint(lcolor[0:2], 16, 8)
-			cG, _ := strconv.ParseUint(lcolor[2:4], 16, 8)
-			cB, _ := strconv.ParseUint(lcolor[4:6], 16, 8)
-
-			labels = append(labels, TIssueLabel{name: label,
-				colorR: uint8(cR),
-				colorG: uint8(cG),
-				colorB: uint8(cB)})
-		}
-
-		issues = append(issues, TIssue{
-			id:        uint(issue.ID),
-			number:    uint(issue.IID),
-			name:      issue.Title,
-			url:       issue.WebURL,
-			author:    issue.Author.Name,
-			assignees: assignees,
-			labels:    labels,
-			creation:  *issue.CreatedAt,
-			content:   issue.Description,
-			is_closed: (issue.State == "closed"),
-		})
+	mentioned := make([]TIssue, 0, len(issues))
+	for idx := range issues {
+		if keep[idx] {
+			mentioned = append(mentioned, issues[idx])
+		}
 	}
 
-	return issues, nil
+	return mentioned, nil
 }
 
 /* Download an specific issue by ID,
@@ -237,7 +429,7 @@ func (gl *TGitLabRepo) DownloadAllIssues(auth *TAuthentication, filter TIssueFil
  * In Gitlab, the ID used to return the issue is the databse ID.
  * The ID in this parameter is the issue number, what gitlab calls 'iid'
  */
-func (gl *TGitLabRepo) DownloadIssue(auth *TAuthentication, id uint) (*TIssue, error) {
+func (gl *TGitLabRepo) DownloadIssue(ctx context.Context, auth *TAuthentication, id uint) (*TIssue, error) {
 
 	var goptions gitlab.ListProjectIssuesOptions
 	goptions.Page = 1
@@ -245,7 +437,7 @@ func (gl *TGitLabRepo) DownloadIssue(auth *TAuthentication, id uint) (*TIssue, e
 	goptions.IIDs = append(make([]int, 0, 1), int(id))
 
 	glissues, _, err := gl.client.Issues.ListProjectIssues(gl.project.ID,
-		&goptions)
+		&goptions, gitlab.WithContext(ctx))
 
 	if err != nil {
 		return nil, err
@@ -255,69 +447,51 @@ func (gl *TGitLabRepo) DownloadIssue(auth *TAuthentication, id uint) (*TIssue, e
 		return nil, nil // No issues do not mean error
 	}
 
-	labelColors, err := gl.getLabels()
+	labelColors, err := gl.getLabels(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	glissue := glissues[0]
-
-	assignees := make([]string, 0, len(glissue.Assignees))
-	for _, assignee := range glissue.Assignees {
-		assignees = append(assignees, assignee.Name)
-	}
+	issue := convertGitLabIssue(glissues[0], labelColors)
+	return &issue, nil
+}
 
-	labels := make([]TIssueLabel, 0, len(glissue.Labels))
-	for _, label := range glissue.Labels {
-		// Gitlab returned label colors have a "#" prefix,
-		// like in '#ff0000'. We need to take it out
-		lcolor := labelColors[label][1:]
-		cR, _ := strconv.ParseUint(lcolor[0:2], 16, 8)
-		cG, _ := strconv.ParseUint(lcolor[2:4], 16, 8)
-		cB, _ := strconv.ParseUint(lcolor[4:6], 16, 8)
+/* Download every page of the notes of the issue 'issue_id'. They have
+ * the comments, and the notes Gitlab makes itself
+ */
+func (gl *TGitLabRepo) downloadIssueNotes(ctx context.Context,
+	issue_id uint) ([]*gitlab.Note, error) {
 
-		labels = append(labels, TIssueLabel{name: label,
-			colorR: uint8(cR),
-			colorG: uint8(cG),
-			colorB: uint8(cB)})
-	}
+	notes := make([]*gitlab.Note, 0)
+	var goptions gitlab.ListIssueNotesOptions
+	goptions.Page = 1
+	goptions.PerPage = 100
+	for {
+		glnotes, resp, err := gl.client.Notes.ListIssueNotes(gl.project.ID,
+			int(issue_id), &goptions, gitlab.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		notes = append(notes, glnotes...)
 
-	issue := &TIssue{
-		id:        uint(glissue.ID),
-		number:    uint(glissue.IID),
-		name:      glissue.Title,
-		url:       glissue.WebURL,
-		author:    glissue.Author.Name,
-		assignees: assignees,
-		labels:    labels,
-		creation:  *glissue.CreatedAt,
-		content:   glissue.Description,
-		is_closed: (glissue.State == "closed"),
+		if resp.NextPage == 0 {
+			return notes, nil
+		}
+		goptions.Page = resp.NextPage
 	}
-
-	return issue, nil
 }
 
 /* Download all comments from that issue
  *
  * Gitlab calls them notes, but is the same thing
  */
-func (gl *TGitLabRepo) DownloadIssueComments(auth *TAuthentication, issue_id uint) ([]TIssueComment, error) {
-
-	var goptions gitlab.ListIssueNotesOptions
-	goptions.Page = 1
-	goptions.PerPage = 100
-	notes, _, err := gl.client.Notes.ListIssueNotes(gl.project.ID,
-		int(issue_id), &goptions)
+func (gl *TGitLabRepo) DownloadIssueComments(ctx context.Context, auth *TAuthentication, issue_id uint) ([]TIssueComment, error) {
 
+	notes, err := gl.downloadIssueNotes(ctx, issue_id)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(notes) < 0 {
-		return nil, nil
-	}
-
 	comments := make([]TIssueComment, 0, len(notes))
 
 	for _, note := range notes {
@@ -326,10 +500,16 @@ func (gl *TGitLabRepo) DownloadIssueComments(auth *TAuthentication, issue_id uin
 			continue
 		}
 
+		// Notes Gitlab makes itself, like 'changed the description', are
+		// events, not comments
+		if note.System {
+			continue
+		}
+
 		comments = append(comments, TIssueComment{
 			id:       uint(note.ID),
 			url:      "", // Looks like we don't have an URL for this issue? Return the issue URL instead?
-			author:   note.Author.Name,
+			author:   note.Author.Username,
 			creation: *note.CreatedAt,
 			content:  note.Body,
 		})
@@ -337,3 +517,562 @@ func (gl *TGitLabRepo) DownloadIssueComments(auth *TAuthentication, issue_id uin
 
 	return comments, nil
 }
+
+/* Create an issue with the title 'title', the content 'content' and the
+ * labels 'labels'. Return the created issue
+ */
+func (gl *TGitLabRepo) CreateIssue(ctx context.Context, auth *TAuthentication,
+	title, content string, labels []string) (*TIssue, error) {
+
+	glissue, _, err := gl.client.Issues.CreateIssue(gl.project.ID,
+		&gitlab.CreateIssueOptions{
+			Title:       &title,
+			Description: &content,
+			Labels:      labels,
+		}, gitlab.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	labelColors, err := gl.getLabels(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	issue := convertGitLabIssue(glissue, labelColors)
+	return &issue, nil
+}
+
+/* Add a comment to the issue 'issue_id'. Return the created comment
+ *
+ * Gitlab calls them notes, but is the same thing
+ */
+func (gl *TGitLabRepo) CreateIssueComment(ctx context.Context, auth *TAuthentication,
+	issue_id uint, content string) (*TIssueComment, error) {
+
+	note, _, err := gl.client.Notes.CreateIssueNote(gl.project.ID,
+		int(issue_id), &gitlab.CreateIssueNoteOptions{Body: &content},
+		gitlab.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	return &TIssueComment{
+		id:       uint(note.ID),
+		url:      "",
+		author:   note.Author.Username,
+		creation: *note.CreatedAt,
+		content:  note.Body,
+	}, nil
+}
+
+/* Close the issue 'issue_id', or reopen it if 'closed' is false */
+func (gl *TGitLabRepo) SetIssueState(ctx context.Context, auth *TAuthentication,
+	issue_id uint, closed bool) error {
+
+	event := "reopen"
+	if closed {
+		event = "close"
+	}
+
+	_, _, err := gl.client.Issues.UpdateIssue(gl.project.ID, int(issue_id),
+		&gitlab.UpdateIssueOptions{StateEvent: &event},
+		gitlab.WithContext(ctx))
+	return err
+}
+
+/* Add the labels 'add' and remove the labels 'remove' from the issue
+ * 'issue_id'
+ *
+ * Gitlab only lets us set the whole label list, so we need to get the
+ * current one first. Our Gitlab library leaves an empty label list out of
+ * the request, so we send it ourselves, or the last label couldn't be
+ * removed
+ */
+func (gl *TGitLabRepo) EditIssueLabels(ctx context.Context, auth *TAuthentication,
+	issue_id uint, add, remove []string) error {
+
+	glissue, _, err := gl.client.Issues.GetIssue(gl.project.ID, int(issue_id),
+		gitlab.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+
+	labels := make([]string, 0, len(glissue.Labels)+len(add))
+	for _, label := range append(glissue.Labels, add...) {
+		if !containsFold(remove, label) && !containsFold(labels, label) {
+			labels = append(labels, label)
+		}
+	}
+
+	path := fmt.Sprintf("projects/%d/issues/%d", gl.project.ID, issue_id)
+	req, err := gl.client.NewRequest("PUT", path, &struct {
+		Labels string `json:"labels"`
+	}{strings.Join(labels, ",")}, []gitlab.OptionFunc{gitlab.WithContext(ctx)})
+	if err != nil {
+		return err
+	}
+
+	_, err = gl.client.Do(req, nil)
+	return err
+}
+
+/* Get the ID of the user with the username 'username'
+ * Return a 404 RepoConnectError if there's no such user
+ */
+func (gl *TGitLabRepo) findUserID(ctx context.Context, username string) (int, error) {
+	users, _, err := gl.client.Users.ListUsers(
+		&gitlab.ListUsersOptions{Username: &username},
+		gitlab.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	if len(users) == 0 {
+		return 0, &RepoConnectError{"No user named " + username, 404}
+	}
+
+	return users[0].ID, nil
+}
+
+/* Add the users 'assignees' to the assignees of the issue 'issue_id'
+ * Send no users to assign the authenticated user
+ *
+ * Gitlab wants user IDs, and the whole assignee list, like with labels
+ */
+func (gl *TGitLabRepo) AssignIssue(ctx context.Context, auth *TAuthentication,
+	issue_id uint, assignees []string) error {
+
+	ids := make([]int, 0, len(assignees))
+	if len(assignees) == 0 {
+		user, _, err := gl.client.Users.CurrentUser(gitlab.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		ids = append(ids, user.ID)
+	}
+
+	for _, username := range assignees {
+		id, err := gl.findUserID(ctx, username)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+
+	glissue, _, err := gl.client.Issues.GetIssue(gl.project.ID, int(issue_id),
+		gitlab.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+
+	for _, assignee := range glissue.Assignees {
+		found := false
+		for _, id := range ids {
+			found = found || id == assignee.ID
+		}
+		if !found {
+			ids = append(ids, assignee.ID)
+		}
+	}
+
+	_, _, err = gl.client.Issues.UpdateIssue(gl.project.ID, int(issue_id),
+		&gitlab.UpdateIssueOptions{AssigneeIDs: ids},
+		gitlab.WithContext(ctx))
+	return err
+}
+
+/* A merge request, as the issue endpoints send them. Only what we use */
+type TGitLabMergeRequest struct {
+	IID    int    `json:"iid"`
+	Title  string `json:"title"`
+	WebURL string `json:"web_url"`
+	State  string `json:"state"`
+}
+
+/* Download what is at the issue endpoint 'endpoint', like 'closed_by',
+ * into the slice 'result' points to, with every page of it. Our Gitlab
+ * library doesn't know these endpoints
+ *
+ * Return a 404 RepoConnectError if the endpoint doesn't exist, like in
+ * older Gitlab versions
+ */
+func (gl *TGitLabRepo) downloadIssueResource(ctx context.Context,
+	issue_id uint, endpoint string, result interface{}) error {
+
+	path := fmt.Sprintf("projects/%d/issues/%d/%s", gl.project.ID, issue_id,
+		endpoint)
+
+	// We don't know the type of the items, so gather them as they came
+	// and decode them at the end
+	items := make([]json.RawMessage, 0)
+	options := gitlab.ListOptions{Page: 1, PerPage: 100}
+	for {
+		req, err := gl.client.NewRequest("GET", path, &options,
+			[]gitlab.OptionFunc{gitlab.WithContext(ctx)})
+		if err != nil {
+			return err
+		}
+
+		var page []json.RawMessage
+		resp, err := gl.client.Do(req, &page)
+		if resp != nil && resp.Response != nil && resp.StatusCode == 404 {
+			return &RepoConnectError{"Gitlab doesn't have " + endpoint, 404}
+		} else if err != nil {
+			return err
+		}
+		items = append(items, page...)
+
+		// Endpoints without pages don't send the next one
+		if resp.NextPage == 0 {
+			break
+		}
+		options.Page = resp.NextPage
+	}
+
+	data, err := json.Marshal(items)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, result)
+}
+
+/* Download the merge requests that mention or close the issue 'issue_id'
+ *
+ * Gitlab has one list of the ones that close it when merged, and another
+ * of the ones that mention it
+ */
+func (gl *TGitLabRepo) DownloadIssueReferences(ctx context.Context, auth *TAuthentication,
+	issue_id uint) ([]TIssueReference, error) {
+
+	var closing, related []TGitLabMergeRequest
+	err := gl.downloadIssueResource(ctx, issue_id, "closed_by", &closing)
+	if err != nil {
+		return nil, err
+	}
+
+	// Older Gitlab versions don't have this one, so it's fine if it fails
+	_ = gl.downloadIssueResource(ctx, issue_id, "related_merge_requests",
+		&related)
+
+	refs := make([]TIssueReference, 0, len(closing)+len(related))
+	for idx, mr := range append(closing, related...) {
+		state := mr.State
+		if state == "opened" {
+			state = "open"
+		}
+
+		refs = append(refs, TIssueReference{
+			kind:   "merge request",
+			number: uint(mr.IID),
+			title:  mr.Title,
+			url:    mr.WebURL,
+			state:  state,
+			closes: idx < len(closing),
+		})
+	}
+
+	return mergeIssueReferences(refs, nil), nil
+}
+
+/* The user that did something, in the resource events */
+type TGitLabEventUser struct {
+	Username string `json:"username"`
+	Name     string `json:"name"`
+}
+
+/* A label added to, or removed from an issue */
+type TGitLabLabelEvent struct {
+	User      TGitLabEventUser `json:"user"`
+	CreatedAt time.Time        `json:"created_at"`
+	Action    string           `json:"action"` // 'add' or 'remove'
+	Label     *struct {
+		Name  string `json:"name"`
+		Color string `json:"color"`
+	} `json:"label"`
+}
+
+/* An issue closed or reopened, maybe by a commit or a merge request */
+type TGitLabStateEvent struct {
+	User               TGitLabEventUser     `json:"user"`
+	CreatedAt          time.Time            `json:"created_at"`
+	State              string               `json:"state"`
+	SourceCommit       string               `json:"source_commit"`
+	SourceMergeRequest *TGitLabMergeRequest `json:"source_merge_request"`
+}
+
+/* Download what happened to the issue 'issue_id', besides the comments
+ *
+ * Labels and states have their own event lists. Everything else is only
+ * in the notes Gitlab makes itself (the system notes), as text
+ */
+func (gl *TGitLabRepo) DownloadIssueEvents(ctx context.Context, auth *TAuthentication,
+	issue_id uint) ([]TIssueEvent, error) {
+
+	// Very old Gitlab versions don't have label events either. Then we
+	// only have the system notes
+	var labelEvents []TGitLabLabelEvent
+	err := gl.downloadIssueResource(ctx, issue_id, "resource_label_events",
+		&labelEvents)
+	if rerr, ok := err.(*RepoConnectError); ok && rerr.ErrorCode == 404 {
+		labelEvents = nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Only newer Gitlab versions have state events. The older ones have
+	// system notes for them
+	var stateEvents []TGitLabStateEvent
+	_ = gl.downloadIssueResource(ctx, issue_id, "resource_state_events",
+		&stateEvents)
+
+	notes, err := gl.downloadIssueNotes(ctx, issue_id)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]TIssueEvent, 0, len(labelEvents)+len(stateEvents)+len(notes))
+	for _, glevent := range labelEvents {
+		if glevent.Label == nil {
+			continue // The label was deleted
+		}
+
+		kind := "labeled"
+		if glevent.Action == "remove" {
+			kind = "unlabeled"
+		}
+
+		events = append(events, TIssueEvent{
+			kind:    kind,
+			actor:   glevent.User.Username,
+			created: glevent.CreatedAt,
+			label:   newIssueLabel(glevent.Label.Name, glevent.Label.Color),
+		})
+	}
+
+	for _, glevent := range stateEvents {
+		event := TIssueEvent{
+			kind:    glevent.State,
+			actor:   glevent.User.Username,
+			created: glevent.CreatedAt,
+		}
+
+		if mr := glevent.SourceMergeRequest; mr != nil {
+			event.ref = &TIssueReference{kind: "merge request",
+				number: uint(mr.IID), title: mr.Title, url: mr.WebURL,
+				closes: glevent.State == "closed"}
+		} else if glevent.SourceCommit != "" {
+			event.ref = &TIssueReference{kind: "commit",
+				id: glevent.SourceCommit, closes: glevent.State == "closed"}
+		}
+
+		events = append(events, event)
+	}
+
+	for _, note := range notes {
+		if !note.System || note.NoteableType != "Issue" {
+			continue
+		}
+
+		if len(stateEvents) > 0 && (strings.HasPrefix(note.Body, "closed") ||
+			strings.HasPrefix(note.Body, "reopened")) {
+			continue
+		}
+
+		events = append(events, TIssueEvent{
+			kind:    "note",
+			actor:   note.Author.Username,
+			created: *note.CreatedAt,
+			detail:  note.Body,
+		})
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].created.Before(events[j].created)
+	})
+
+	return events, nil
+}
+
+/* Create the label 'label' */
+func (gl *TGitLabRepo) CreateLabel(ctx context.Context, auth *TAuthentication,
+	label TRepoLabel) error {
+
+	color := "#" + label.hexColor()
+	_, _, err := gl.client.Labels.CreateLabel(gl.project.ID,
+		&gitlab.CreateLabelOptions{
+			Name:        &label.name,
+			Color:       &color,
+			Description: &label.description,
+		}, gitlab.WithContext(ctx))
+	return err
+}
+
+/* Change the label named 'name' into 'label' */
+func (gl *TGitLabRepo) EditLabel(ctx context.Context, auth *TAuthentication,
+	name string, label TRepoLabel) error {
+
+	options := gitlab.UpdateLabelOptions{Name: &name}
+	color := "#" + label.hexColor()
+	options.Color = &color
+	options.Description = &label.description
+
+	// Gitlab doesn't like being asked to rename a label to its own name
+	if label.name != name {
+		options.NewName = &label.name
+	}
+
+	_, _, err := gl.client.Labels.UpdateLabel(gl.project.ID, &options,
+		gitlab.WithContext(ctx))
+	return err
+}
+
+/* Delete the label named 'name' */
+func (gl *TGitLabRepo) DeleteLabel(ctx context.Context, auth *TAuthentication,
+	name string) error {
+
+	_, err := gl.client.Labels.DeleteLabel(gl.project.ID,
+		&gitlab.DeleteLabelOptions{Name: &name}, gitlab.WithContext(ctx))
+	return err
+}
+
+/* Count the issues in the milestone 'title' that are in the state 'state'
+ * ('opened' or 'closed'). Gitlab tells us the total in X-Total, so we only
+ * need to ask for one of them
+ */
+func (gl *TGitLabRepo) countMilestoneIssues(ctx context.Context, title, state string) (int, error) {
+	options := gitlab.ListProjectIssuesOptions{Milestone: &title, State: &state}
+	options.Page = 1
+	options.PerPage = 1
+
+	_, resp, err := gl.client.Issues.ListProjectIssues(gl.project.ID, &options,
+		gitlab.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+
+	return resp.TotalItems, nil
+}
+
+/* Download the milestones of the project in the state 'state'
+ * Gitlab doesn't send how many issues each one has, so with 'counts' we
+ * count them, all milestones at the same time. It takes two requests for
+ * each one, so only do it for the ones we show
+ */
+func (gl *TGitLabRepo) DownloadMilestones(ctx context.Context, auth *TAuthentication,
+	state string, counts bool) ([]TMilestone, error) {
+
+	milestones := make([]TMilestone, 0)
+	options := gitlab.ListMilestonesOptions{}
+	options.Page = 1
+	options.PerPage = 100
+
+	// Open milestones are 'active' in Gitlab
+	switch state {
+	case "open":
+		active := "active"
+		options.State = &active
+	case "closed":
+		options.State = &state
+	}
+	for {
+		glmilestones, _, err := gl.client.Milestones.ListMilestones(gl.project.ID,
+			&options, gitlab.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, m := range glmilestones {
+			milestone := TMilestone{
+				id:          uint(m.ID),
+				title:       m.Title,
+				description: m.Description,
+				is_closed:   m.State == "closed",
+				url:         m.WebURL,
+			}
+			if m.DueDate != nil {
+				milestone.due = time.Time(*m.DueDate)
+			}
+
+			milestones = append(milestones, milestone)
+		}
+
+		if len(glmilestones) < options.PerPage {
+			break
+		}
+		options.Page++
+	}
+
+	if counts {
+		err := fetchPages(ctx, 0, len(milestones)-1, func(ctx context.Context, idx int) error {
+			var err error
+			milestone := &milestones[idx]
+			if milestone.openIssues, err = gl.countMilestoneIssues(ctx,
+				milestone.title, "opened"); err != nil {
+				return err
+			}
+
+			milestone.closedIssues, err = gl.countMilestoneIssues(ctx,
+				milestone.title, "closed")
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Github sends them by due date, so we do the same
+	sort.SliceStable(milestones, func(i, j int) bool {
+		di, dj := milestones[i].due, milestones[j].due
+		return !di.IsZero() && (dj.IsZero() || di.Before(dj))
+	})
+
+	return milestones, nil
+}
+
+/* Create the milestone 'milestone' */
+func (gl *TGitLabRepo) CreateMilestone(ctx context.Context, auth *TAuthentication,
+	milestone TMilestone) error {
+
+	options := gitlab.CreateMilestoneOptions{
+		Title:       &milestone.title,
+		Description: &milestone.description,
+	}
+	if !milestone.due.IsZero() {
+		due := gitlab.ISOTime(milestone.due)
+		options.DueDate = &due
+	}
+
+	created, _, err := gl.client.Milestones.CreateMilestone(gl.project.ID,
+		&options, gitlab.WithContext(ctx))
+	if err != nil || !milestone.is_closed {
+		return err
+	}
+
+	// Milestones are created open
+	milestone.id = uint(created.ID)
+	return gl.EditMilestone(ctx, auth, milestone)
+}
+
+/* Change the milestone 'milestone.id' into 'milestone' */
+func (gl *TGitLabRepo) EditMilestone(ctx context.Context, auth *TAuthentication,
+	milestone TMilestone) error {
+
+	stateEvent := "activate"
+	if milestone.is_closed {
+		stateEvent = "close"
+	}
+
+	options := gitlab.UpdateMilestoneOptions{
+		Title:       &milestone.title,
+		Description: &milestone.description,
+		StateEvent:  &stateEvent,
+	}
+	if !milestone.due.IsZero() {
+		due := gitlab.ISOTime(milestone.due)
+		options.DueDate = &due
+	}
+
+	_, _, err := gl.client.Milestones.UpdateMilestone(gl.project.ID,
+		int(milestone.id), &options, gitlab.WithContext(ctx))
+	return err
+}
@@ -0,0 +1,241 @@
+package main
+
+/**
+ * Issue history
+ *
+ * 'issues history' shows everything that happened to an issue, oldest
+ * first: the comments, and the events the host records (labels,
+ * assignments, closes, renames, references...).
+ *
+ * Copyright (C) 2018 Arthur M
+ */
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* Something in the history of an issue: an event or a comment */
+type THistoryEntry struct {
+	created time.Time
+	event   *TIssueEvent
+	comment *TIssueComment
+}
+
+/* Put the events and the comments of an issue together, oldest first
+ * The issue being opened is the first event
+ */
+func buildIssueHistory(issue *TIssue, events []TIssueEvent,
+	comments []TIssueComment) []THistoryEntry {
+
+	entries := make([]THistoryEntry, 0, len(events)+len(comments)+1)
+	entries = append(entries, THistoryEntry{
+		created: issue.creation,
+		event: &TIssueEvent{kind: "opened", actor: issue.author,
+			created: issue.creation},
+	})
+
+	for idx := range events {
+		entries = append(entries, THistoryEntry{created: events[idx].created,
+			event: &events[idx]})
+	}
+
+	for idx := range comments {
+		entries = append(entries, THistoryEntry{created: comments[idx].creation,
+			comment: &comments[idx]})
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].created.Before(entries[j].created)
+	})
+
+	return entries
+}
+
+/* Describe a reference, like 'pull request #12 (Fix the crash)' */
+func describeReference(ref *TIssueReference) string {
+	if ref.kind == "commit" {
+		id := ref.id
+		if len(id) > 7 {
+			id = id[:7]
+		}
+		return "commit " + ansiStyle("33", id)
+	}
+
+	desc := ref.kind + " " + ansiStyle("1", ref.repo+"#"+strconv.Itoa(int(ref.number)))
+	if ref.title != "" {
+		desc += " (" + ref.title + ")"
+	}
+	return desc
+}
+
+/* Describe what the event 'event' did, like 'added the label bug' */
+func describeIssueEvent(event *TIssueEvent) string {
+	switch event.kind {
+	case "opened":
+		return "opened the issue"
+	case "labeled":
+		return "added the label " + labelChip(event.label)
+	case "unlabeled":
+		return "removed the label " + labelChip(event.label)
+	case "assigned":
+		if event.user == event.actor {
+			return "self-assigned it"
+		}
+		return "assigned " + ansiStyle("36;1", event.user)
+	case "unassigned":
+		if event.user == event.actor {
+			return "unassigned themselves"
+		}
+		return "unassigned " + ansiStyle("36;1", event.user)
+	case "closed", "reopened":
+		desc := ansiStyle("31;1", "closed") + " it"
+		if event.kind == "reopened" {
+			desc = ansiStyle("32;1", "reopened") + " it"
+		}
+		if event.ref != nil {
+			desc += " in " + describeReference(event.ref)
+		}
+		return desc
+	case "renamed":
+		return "changed the title from \"" + event.from + "\" to \"" +
+			event.to + "\""
+	case "referenced":
+		if event.ref == nil {
+			return "referenced it"
+		}
+		desc := "referenced it in " + describeReference(event.ref)
+		if event.ref.closes {
+			desc += ", that closes it"
+		}
+		return desc
+	case "milestoned":
+		return "added it to the milestone " + ansiStyle("1", event.detail)
+	case "demilestoned":
+		return "removed it from the milestone " + ansiStyle("1", event.detail)
+	case "note":
+		return event.detail
+	}
+
+	// Events we don't know, like 'review_requested'
+	if event.detail != "" {
+		return strings.Replace(event.kind, "_", " ", -1) + ": " + event.detail
+	}
+	return strings.Replace(event.kind, "_", " ", -1)
+}
+
+/* Convert a history entry to the format --format json prints */
+func historyEntryToJSON(entry THistoryEntry) TJSONEvent {
+	if comment := entry.comment; comment != nil {
+		return TJSONEvent{Kind: "comment", Actor: comment.author,
+			CreatedAt: comment.creation, Body: comment.content,
+			URL: comment.url}
+	}
+
+	event := entry.event
+	jevent := TJSONEvent{
+		Kind:      event.kind,
+		Actor:     event.actor,
+		CreatedAt: event.created,
+		User:      event.user,
+		From:      event.from,
+		To:        event.to,
+		Detail:    event.detail,
+	}
+
+	if event.kind == "labeled" || event.kind == "unlabeled" {
+		jevent.Label = &TJSONLabel{Name: event.label.name,
+			Color: event.label.hexColor()}
+	}
+
+	if ref := event.ref; ref != nil {
+		jevent.Reference = &TJSONReference{Kind: ref.kind, Number: ref.number,
+			Commit: ref.id, Repo: ref.repo, Title: ref.title, URL: ref.url,
+			State: ref.state, Closes: ref.closes}
+	}
+
+	return jevent
+}
+
+/* Get the events of the issue 'number' from the host
+ * If we are offline, or the host can't be reached, we only have the
+ * comments, so say that and return no events
+ */
+func loadIssueEvents(ctx context.Context, ad ArgumentData, number uint) []TIssueEvent {
+	if ad.offline {
+		fmt.Fprint(warningOutput, "Offline: the events of the issue are "+
+			"not cached, showing only the comments\n\n")
+		return nil
+	}
+
+	r, err := findRepositoryHost(ctx, ad.auth)
+	if err == nil {
+		var events []TIssueEvent
+		if events, err = r.DownloadIssueEvents(ctx, ad.auth, number); err == nil {
+			return events
+		}
+	}
+
+	if !isNetworkError(err) {
+		panic(err)
+	}
+
+	fmt.Fprintf(warningOutput, "Could not download the events of the issue "+
+		"(%s), showing only the comments\n\n", err.Error())
+	return nil
+}
+
+func _issueHistory(ctx context.Context, ad ArgumentData, args []string) {
+	if len(args) < 2 || args[1] == "help" {
+		fmt.Println(args[0] + " <issue_num>")
+		fmt.Println(" Show everything that happened to an issue, oldest first: the")
+		fmt.Println(" comments, labels, assignments, closes, renames and references")
+		fmt.Println()
+		return
+	}
+
+	if isTableFormat(ad.format) || ad.template != nil {
+		panic("The history can only be printed as text, json or ndjson")
+	}
+
+	number := parseIssueNumber(args)
+	issue, comments := loadIssue(ctx, ad, number)
+	events := loadIssueEvents(ctx, ad, number)
+	entries := buildIssueHistory(issue, events, comments)
+
+	startPager(ad)
+	if isStructuredFormat(ad.format) {
+		list := newStructuredList(ad.format)
+		for _, entry := range entries {
+			list.add(historyEntryToJSON(entry))
+		}
+		list.finish()
+		return
+	}
+
+	title := ansiStyle("33;1", issue.name)
+	if issue.is_closed {
+		title = ansiStyle("31;1", issue.name)
+	}
+	fmt.Printf("\t#%s - %s\n\n", ansiStyle("1", strconv.Itoa(int(issue.number))),
+		title)
+
+	for _, entry := range entries {
+		when := ansiStyle("2", entry.created.Local().Format("2006-01-02 15:04"))
+
+		if comment := entry.comment; comment != nil {
+			fmt.Printf("\t%s  %s commented:\n", when,
+				ansiStyle("33", comment.author))
+			fmt.Println(formatBody(ad, comment.content, "\t\t\t", 24))
+			fmt.Println()
+			continue
+		}
+
+		fmt.Printf("\t%s  %s %s\n", when, ansiStyle("36;1", entry.event.actor),
+			describeIssueEvent(entry.event))
+	}
+}
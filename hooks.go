@@ -0,0 +1,414 @@
+package main
+
+/**
+ * Git hooks that link commits to issues
+ *
+ * 'hooks install' installs two hooks in the repository:
+ *  - prepare-commit-msg adds 'Refs #N' (or 'Closes #N') to the commit
+ *    message, where N is the issue of the current branch (the one
+ *    'issues start' records)
+ *  - commit-msg warns when the message mentions issues that don't exist or
+ *    are closed
+ *
+ * The hooks only call shissue back ('shissue hooks <hook> <args>'), so
+ * they always do what the installed version does.
+ *
+ * Copyright (C) 2018 Arthur M
+ */
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/* The hooks we install */
+var shissueHooks = []string{"prepare-commit-msg", "commit-msg"}
+
+/* Line that marks a hook as ours, so we don't touch anyone else's */
+const hookMarker = "# Installed by shissue"
+
+/* Issue references in commit messages, like '#42' or '(#42)', but not
+ * 'author/repo#42' or URL anchors
+ */
+var issueRefRegex = regexp.MustCompile(`(?:^|[\s(\[,;])#([0-9]+)\b`)
+
+/* Lines that look like trailers, like 'Signed-off-by: someone' or
+ * 'Refs #42'
+ */
+var trailerRegex = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9-]*(: .*| #[0-9]+)$`)
+
+/* Get the directory git runs the hooks from (it can be changed with
+ * core.hooksPath)
+ */
+func getHooksDir() (string, error) {
+	out, err := exec.Command("git", "rev-parse", "--git-path", "hooks").Output()
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+/* Get the script of the hook 'hook', that calls us back */
+func hookScript(hook string) string {
+	shissue, err := os.Executable()
+	if err != nil {
+		shissue = "shissue"
+	}
+
+	return "#!/bin/sh\n" +
+		hookMarker + ". Run 'shissue hooks uninstall' to remove it\n" +
+		"SHISSUE='" + strings.Replace(shissue, "'", `'\''`, -1) + "'\n" +
+		"[ -x \"$SHISSUE\" ] || SHISSUE=shissue\n" +
+		"command -v \"$SHISSUE\" >/dev/null 2>&1 || exit 0\n" +
+		"exec \"$SHISSUE\" hooks " + hook + " \"$@\"\n"
+}
+
+/* Check if the hook file 'path' was installed by us */
+func isShissueHook(path string) bool {
+	content, err := ioutil.ReadFile(path)
+	return err == nil && strings.Contains(string(content), hookMarker)
+}
+
+/* Get the comment character git uses in commit messages */
+func getCommentChar() string {
+	char, err := getGitProperty("core.commentChar")
+	if err != nil || char == "" || char == "auto" {
+		return "#"
+	}
+	return char
+}
+
+/* Split a commit message in what the user wrote and the part git will
+ * strip: the comments at the end, and everything after the scissors line
+ * of 'git commit -v'. 'comment' is the comment character
+ */
+func splitCommitMessage(content, comment string) (message, rest []string) {
+	lines := strings.Split(strings.TrimRight(content, "\n"), "\n")
+
+	end := len(lines)
+	for idx, line := range lines {
+		if strings.HasPrefix(line, comment+" ") && strings.Contains(line, " >8 ") {
+			end = idx
+			break
+		}
+	}
+
+	for end > 0 && (strings.TrimSpace(lines[end-1]) == "" ||
+		strings.HasPrefix(lines[end-1], comment)) {
+		end--
+	}
+
+	message = make([]string, 0, end)
+	for _, line := range lines[:end] {
+		if !strings.HasPrefix(line, comment) {
+			message = append(message, line)
+		}
+	}
+
+	return message, lines[end:]
+}
+
+/* Get the issue numbers 'message' mentions, in the order they appear */
+func findIssueReferences(message []string) []uint {
+	numbers := make([]uint, 0)
+	seen := make(map[uint]bool)
+	for _, line := range message {
+		for _, match := range issueRefRegex.FindAllStringSubmatch(line, -1) {
+			number, err := strconv.ParseUint(match[1], 10, 32)
+			if err != nil || number == 0 || seen[uint(number)] {
+				continue
+			}
+
+			seen[uint(number)] = true
+			numbers = append(numbers, uint(number))
+		}
+	}
+
+	return numbers
+}
+
+/* Get the issue linked to the current branch, or 0 if there's none */
+func getBranchIssue() uint {
+	out, err := exec.Command("git", "symbolic-ref", "-q", "--short", "HEAD").Output()
+	if err != nil {
+		return 0
+	}
+
+	value, err := getGitProperty("branch." + strings.TrimSpace(string(out)) +
+		".shissue-issue")
+	if err != nil {
+		return 0
+	}
+
+	number, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return 0
+	}
+
+	return uint(number)
+}
+
+/* The prepare-commit-msg hook: add the issue of the branch to the message
+ * in 'args[0]', unless it's already there
+ */
+func prepareCommitMessage(args []string) {
+	if len(args) < 1 {
+		panic("Commit message file not specified")
+	}
+
+	// Merges, squashes and amends already have their message
+	if len(args) > 1 && (args[1] == "merge" || args[1] == "squash" ||
+		args[1] == "commit") {
+		return
+	}
+
+	number := getBranchIssue()
+	if number == 0 {
+		return
+	}
+
+	content, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		panic(err)
+	}
+
+	keyword, err := getGitProperty("shissue.commitTrailer")
+	if err != nil || keyword == "" {
+		keyword = "Refs"
+	}
+
+	text, changed := addIssueTrailer(string(content), getCommentChar(),
+		keyword+" #"+strconv.Itoa(int(number)), number)
+	if !changed {
+		return
+	}
+
+	if err := ioutil.WriteFile(args[0], []byte(text), 0644); err != nil {
+		panic(err)
+	}
+}
+
+/* Add the trailer 'trailer' for the issue 'number' to the commit message
+ * 'content', unless the message already mentions the issue. 'comment' is
+ * the comment character
+ *
+ * Return the new message, and if it changed
+ */
+func addIssueTrailer(content, comment, trailer string, number uint) (string, bool) {
+	message, rest := splitCommitMessage(content, comment)
+	for _, ref := range findIssueReferences(message) {
+		if ref == number {
+			return content, false
+		}
+	}
+
+	for len(message) > 0 && strings.TrimSpace(message[len(message)-1]) == "" {
+		message = message[:len(message)-1]
+	}
+
+	// Join the other trailers, if the message ends with some. If it's
+	// empty, leave the first line for the title
+	if len(message) == 0 {
+		message = []string{"", ""}
+	} else if !endsWithTrailers(message) {
+		message = append(message, "")
+	}
+	message = append(message, trailer)
+
+	if len(rest) > 0 {
+		message = append(append(message, ""), rest...)
+	}
+
+	return strings.Join(message, "\n") + "\n", true
+}
+
+/* Check if the last paragraph of 'message' is made of trailers, like
+ * 'Signed-off-by: someone'. The subject never is, even if it looks like
+ * one, like 'fix: crash on start'
+ */
+func endsWithTrailers(message []string) bool {
+	for idx := len(message) - 1; idx > 0; idx-- {
+		if strings.TrimSpace(message[idx]) == "" {
+			return idx < len(message)-1
+		}
+
+		if !trailerRegex.MatchString(message[idx]) {
+			return false
+		}
+	}
+
+	return false
+}
+
+/* The commit-msg hook: warn about the issues the message in 'args[0]'
+ * mentions that don't exist or are closed
+ *
+ * It only warns, because the host might know about issues we don't
+ */
+func checkCommitMessage(ctx context.Context, ad ArgumentData, args []string) {
+	if len(args) < 1 {
+		panic("Commit message file not specified")
+	}
+
+	content, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		panic(err)
+	}
+
+	message, _ := splitCommitMessage(string(content), getCommentChar())
+	numbers := findIssueReferences(message)
+	if len(numbers) == 0 {
+		return
+	}
+
+	// Don't make the user wait for the host if the cache is fresh
+	var cache *TIssueCache
+	var host TRepoHost
+	if ad.offline || !ad.noCache {
+		if c, err := openIssueCache(getCurrentRepository()); err == nil &&
+			(ad.offline || c.isFresh(getCacheTTL())) {
+			cache = c
+		}
+	}
+
+	if cache == nil && ad.offline {
+		return
+	}
+
+	if cache == nil {
+		host, err = findRepositoryHost(ctx, ad.auth)
+		if err != nil {
+			if cache, err = openIssueCache(getCurrentRepository()); err != nil {
+				return
+			}
+		}
+	}
+
+	for _, number := range numbers {
+		var issue *TIssue
+		if host != nil {
+			issue, err = host.DownloadIssue(ctx, ad.auth, number)
+			if err != nil {
+				// Use what we synced for the rest of them
+				host = nil
+				if cache, err = openIssueCache(getCurrentRepository()); err != nil {
+					return
+				}
+			}
+		}
+
+		if host == nil {
+			if issue, _ = cache.getIssue(number); issue == nil {
+				if !cache.LastSync.IsZero() {
+					fmt.Fprintf(os.Stderr, "Warning: issue #%d was not found "+
+						"in the issues synced %s\n", number,
+						relativeTime(cache.LastSync))
+				}
+				continue
+			}
+		}
+
+		// Squash merges on Github put the pull request in the subject, like
+		// '(#42)', and Github sends it as an issue. It's closed once merged,
+		// so reverting or cherry-picking the commit is no reason to warn
+		if issue == nil {
+			fmt.Fprintf(os.Stderr, "Warning: issue #%d doesn't exist\n", number)
+		} else if issue.is_closed && !issue.isPullRequest {
+			fmt.Fprintf(os.Stderr, "Warning: issue #%d (%s) is closed\n",
+				number, issue.name)
+		}
+	}
+}
+
+/* Install our hooks, replacing the ones already there only if they are
+ * ours, or if 'force' is set
+ */
+func installHooks(force bool) {
+	dir, err := getHooksDir()
+	if err != nil {
+		panic(err)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		panic(err)
+	}
+
+	for _, hook := range shissueHooks {
+		path := filepath.Join(dir, hook)
+		if _, err := os.Stat(path); err == nil && !isShissueHook(path) && !force {
+			fmt.Fprintf(os.Stderr, "Not installing %s: there's already a hook "+
+				"in %s. Use --force to replace it\n", hook, path)
+			continue
+		}
+
+		if err := ioutil.WriteFile(path, []byte(hookScript(hook)), 0755); err != nil {
+			panic(err)
+		}
+		fmt.Println("Installed " + path)
+	}
+}
+
+/* Remove our hooks, and only ours */
+func uninstallHooks() {
+	dir, err := getHooksDir()
+	if err != nil {
+		panic(err)
+	}
+
+	for _, hook := range shissueHooks {
+		path := filepath.Join(dir, hook)
+		if !isShissueHook(path) {
+			continue
+		}
+
+		if err := os.Remove(path); err != nil {
+			panic(err)
+		}
+		fmt.Println("Removed " + path)
+	}
+}
+
+func _hooks(ctx context.Context, ad ArgumentData, args []string) {
+	if len(args) < 2 || args[1] == "help" {
+		fmt.Println(args[0] + " install [--force]|uninstall")
+		fmt.Println(" Install (or remove) git hooks that link commits to issues:")
+		fmt.Println(" \tprepare-commit-msg - adds 'Refs #<n>' to the commit message, where")
+		fmt.Println(" \t                     <n> is the issue of the branch ('issues start')")
+		fmt.Println(" \tcommit-msg         - warns about mentioned issues that don't exist")
+		fmt.Println(" \t                     or are closed")
+		fmt.Println()
+		fmt.Println(" Use 'git config shissue.commitTrailer Closes' to close the issue")
+		fmt.Println(" with the commit instead. Hooks that aren't ours are only replaced")
+		fmt.Println(" with --force")
+		fmt.Println()
+		return
+	}
+
+	switch args[1] {
+	case "install":
+		installHooks(len(args) > 2 && args[2] == "--force")
+	case "uninstall":
+		uninstallHooks()
+	case "prepare-commit-msg":
+		// Neither hook may stop anyone from committing
+		if err := catchPanic(func() { prepareCommitMessage(args[2:]) }); err != nil {
+			fmt.Fprintln(os.Stderr, "Warning: could not add the issue to the "+
+				"commit message: "+err.Error())
+		}
+	case "commit-msg":
+		if err := catchPanic(func() { checkCommitMessage(ctx, ad, args[2:]) }); err != nil {
+			fmt.Fprintln(os.Stderr, "Warning: could not check the issues in the "+
+				"commit message: "+err.Error())
+		}
+	default:
+		panic("Unknown hooks command " + args[1])
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommitMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		comment string
+		message []string
+		rest    []string
+	}{
+		{
+			name:    "comments at the end",
+			content: "Fix crash\n\nBody\n\n# Please enter the message\n# On branch x\n",
+			comment: "#",
+			message: []string{"Fix crash", "", "Body"},
+			rest:    []string{"", "# Please enter the message", "# On branch x"},
+		},
+		{
+			name:    "comments in the middle are dropped",
+			content: "Fix crash\n# a note\nBody\n",
+			comment: "#",
+			message: []string{"Fix crash", "Body"},
+			rest:    []string{},
+		},
+		{
+			name: "scissors",
+			content: "Fix crash\n" +
+				"# ------------------------ >8 ------------------------\n" +
+				"diff --git a/x b/x\n",
+			comment: "#",
+			message: []string{"Fix crash"},
+			rest: []string{"# ------------------------ >8 ------------------------",
+				"diff --git a/x b/x"},
+		},
+		{
+			name:    "other comment character",
+			content: "#42 is fixed\n; a comment\n",
+			comment: ";",
+			message: []string{"#42 is fixed"},
+			rest:    []string{"; a comment"},
+		},
+		{
+			name:    "empty",
+			content: "\n# Please enter the message\n",
+			comment: "#",
+			message: []string{},
+			rest:    []string{"", "# Please enter the message"},
+		},
+	}
+
+	for _, test := range tests {
+		message, rest := splitCommitMessage(test.content, test.comment)
+		if !reflect.DeepEqual(message, test.message) || !reflect.DeepEqual(rest, test.rest) {
+			t.Errorf("%s: got %q and %q, want %q and %q", test.name,
+				message, rest, test.message, test.rest)
+		}
+	}
+}
+
+func TestAddIssueTrailer(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string // Empty if it must not change
+	}{
+		{
+			name:    "subject that looks like a trailer",
+			content: "fix: crash on start\n",
+			want:    "fix: crash on start\n\nRefs #7\n",
+		},
+		{
+			name:    "one paragraph of trailer-like lines",
+			content: "fix: crash on start\nfeat: and a feature\n",
+			want:    "fix: crash on start\nfeat: and a feature\n\nRefs #7\n",
+		},
+		{
+			name:    "joins the other trailers",
+			content: "Fix crash\n\nIt crashed.\n\nSigned-off-by: Someone <s@example.com>\n",
+			want: "Fix crash\n\nIt crashed.\n\nSigned-off-by: Someone <s@example.com>\n" +
+				"Refs #7\n",
+		},
+		{
+			name:    "body that isn't trailers",
+			content: "Fix crash\n\nSee: the docs, and more text\nthat goes on\n",
+			want:    "Fix crash\n\nSee: the docs, and more text\nthat goes on\n\nRefs #7\n",
+		},
+		{
+			name:    "before the comments",
+			content: "Fix crash\n\n# Please enter the message\n",
+			want:    "Fix crash\n\nRefs #7\n\n\n# Please enter the message\n",
+		},
+		{
+			name:    "empty message leaves the subject",
+			content: "\n# Please enter the message\n",
+			want:    "\n\nRefs #7\n\n\n# Please enter the message\n",
+		},
+		{
+			name:    "already mentioned",
+			content: "Fix crash (#7)\n",
+		},
+		{
+			name:    "already closed",
+			content: "Fix crash\n\nCloses #7\n",
+		},
+	}
+
+	for _, test := range tests {
+		got, changed := addIssueTrailer(test.content, "#", "Refs #7", 7)
+		if test.want == "" {
+			if changed {
+				t.Errorf("%s: changed it to %q", test.name, got)
+			}
+			continue
+		}
+
+		if !changed || got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
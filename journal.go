@@ -0,0 +1,609 @@
+package main
+
+/**
+ * Pending operations journal
+ *
+ * Things that change issues (creating them, commenting, closing, reopening,
+ * changing labels and assigning) can be done offline. When we can't reach the host,
+ * they are stored in a journal ('.git/shissue/pending.json'), and the
+ * 'push' command sends them when we are online again.
+ *
+ * Before sending an operation, we check if the issue changed since we last
+ * saw it. If it did, we report a conflict instead of sending it, so we
+ * don't blindly overwrite what someone else did.
+ *
+ * Copyright (C) 2018 Arthur M
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* Operation kinds */
+const (
+	opNewIssue = "new"
+	opComment  = "comment"
+	opClose    = "close"
+	opReopen   = "reopen"
+	opLabel    = "label"
+	opAssign   = "assign"
+)
+
+/* An operation waiting to be sent to the host */
+type TPendingOp struct {
+	ID      uint   `json:"id"`
+	Kind    string `json:"kind"`
+	Issue   uint   `json:"issue,omitempty"` // Not used for new issues
+	Title   string `json:"title,omitempty"` // Only for new issues
+	Content string `json:"body,omitempty"`  // Issue or comment content
+
+	// Labels of a new issue, or labels to add to an issue
+	Labels       []string `json:"labels,omitempty"`
+	RemoveLabels []string `json:"remove_labels,omitempty"`
+
+	// Users to assign to an issue. None means whoever we are logged in as
+	Assignees []string `json:"assignees,omitempty"`
+
+	// What we knew about the issue when the operation was made, so we can
+	// know if someone changed it in the meantime. A zero date means we
+	// didn't know anything
+	BaseUpdated time.Time `json:"base_updated"`
+	BaseClosed  bool      `json:"base_closed"`
+
+	Created time.Time `json:"created_at"`
+}
+
+type TPendingJournal struct {
+	NextID uint         `json:"next_id"`
+	Ops    []TPendingOp `json:"operations"`
+
+	path string // Where the journal file is
+}
+
+/* Open the journal of the repository we are in
+ * If it doesn't exist, return an empty one. It will be created when saved
+ */
+func openPendingJournal() (*TPendingJournal, error) {
+	gitdir, err := getGitDir()
+	if err != nil {
+		return nil, err
+	}
+
+	journal := &TPendingJournal{
+		NextID: 1,
+		path:   filepath.Join(gitdir, "shissue", "pending.json"),
+	}
+
+	data, err := ioutil.ReadFile(journal.path)
+	if os.IsNotExist(err) {
+		return journal, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Unlike the cache, we can't just throw a broken journal away, because
+	// it has things the user wrote
+	if err := json.Unmarshal(data, journal); err != nil {
+		return nil, fmt.Errorf("Broken pending operations journal %s: %s",
+			journal.path, err.Error())
+	}
+
+	return journal, nil
+}
+
+/* Save the journal to its file */
+func (j *TPendingJournal) save() error {
+	if err := os.MkdirAll(filepath.Dir(j.path), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmppath := j.path + ".tmp"
+	if err := ioutil.WriteFile(tmppath, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmppath, j.path)
+}
+
+/* Add an operation to the end of the journal. Return its ID */
+func (j *TPendingJournal) add(op TPendingOp) uint {
+	op.ID = j.NextID
+	op.Created = time.Now()
+	j.NextID++
+
+	j.Ops = append(j.Ops, op)
+	return op.ID
+}
+
+/* Remove the operation with the ID 'id'. Return false if there's none */
+func (j *TPendingJournal) remove(id uint) bool {
+	for idx, op := range j.Ops {
+		if op.ID == id {
+			j.Ops = append(j.Ops[:idx], j.Ops[idx+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+/* Describe the operation, like 'close #12' */
+func (op *TPendingOp) describe() string {
+	issue := "#" + strconv.Itoa(int(op.Issue))
+
+	switch op.Kind {
+	case opNewIssue:
+		return "new issue \"" + op.Title + "\""
+	case opComment:
+		return "comment on " + issue
+	case opClose:
+		return "close " + issue
+	case opReopen:
+		return "reopen " + issue
+	case opLabel:
+		changes := make([]string, 0, len(op.Labels)+len(op.RemoveLabels))
+		for _, label := range op.Labels {
+			changes = append(changes, "+"+label)
+		}
+		for _, label := range op.RemoveLabels {
+			changes = append(changes, "-"+label)
+		}
+		return "labels of " + issue + ": " + strings.Join(changes, " ")
+	case opAssign:
+		if len(op.Assignees) == 0 {
+			return "assign " + issue + " to you"
+		}
+		return "assign " + issue + " to " + strings.Join(op.Assignees, ", ")
+	}
+
+	return op.Kind + " " + issue
+}
+
+/* Send the operation to the host
+ * Return a description of what was done
+ */
+func applyPendingOp(ctx context.Context, r TRepoHost, auth *TAuthentication,
+	op TPendingOp) (string, error) {
+
+	switch op.Kind {
+	case opNewIssue:
+		issue, err := r.CreateIssue(ctx, auth, op.Title, op.Content, op.Labels)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Created issue #%d: %s", issue.number, issue.url), nil
+
+	case opComment:
+		comment, err := r.CreateIssueComment(ctx, auth, op.Issue, op.Content)
+		if err != nil {
+			return "", err
+		}
+
+		if comment.url != "" {
+			return fmt.Sprintf("Commented on #%d: %s", op.Issue, comment.url), nil
+		}
+		return fmt.Sprintf("Commented on #%d", op.Issue), nil
+
+	case opClose, opReopen:
+		err := r.SetIssueState(ctx, auth, op.Issue, op.Kind == opClose)
+		if err != nil {
+			return "", err
+		}
+
+		if op.Kind == opClose {
+			return fmt.Sprintf("Closed #%d", op.Issue), nil
+		}
+		return fmt.Sprintf("Reopened #%d", op.Issue), nil
+
+	case opLabel:
+		err := r.EditIssueLabels(ctx, auth, op.Issue, op.Labels, op.RemoveLabels)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Changed the labels of #%d", op.Issue), nil
+
+	case opAssign:
+		err := r.AssignIssue(ctx, auth, op.Issue, op.Assignees)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Assigned #%d", op.Issue), nil
+	}
+
+	return "", fmt.Errorf("Unknown operation '%s'", op.Kind)
+}
+
+/* Check if the issue the operation changes was changed since the operation
+ * was made
+ * Return why it conflicts, or an empty string if it doesn't
+ */
+func checkPendingOpConflict(ctx context.Context, r TRepoHost,
+	auth *TAuthentication, op TPendingOp) (string, error) {
+
+	if op.Kind == opNewIssue {
+		return "", nil
+	}
+
+	issue, err := r.DownloadIssue(ctx, auth, op.Issue)
+	if err != nil {
+		return "", err
+	}
+
+	if issue == nil {
+		return "the issue doesn't exist anymore", nil
+	}
+
+	if op.Kind == opClose && issue.is_closed {
+		return "the issue was already closed", nil
+	}
+
+	if op.Kind == opReopen && !issue.is_closed {
+		return "the issue was already reopened", nil
+	}
+
+	if op.Kind != opClose && op.Kind != opReopen &&
+		issue.is_closed && !op.BaseClosed {
+		return "the issue was closed in the meantime", nil
+	}
+
+	if !op.BaseUpdated.IsZero() && issue.updated.After(op.BaseUpdated) {
+		return "the issue was changed in the meantime (" +
+			relativeTime(issue.updated) + ")", nil
+	}
+
+	return "", nil
+}
+
+/* Send the operation to the host now, or store it in the journal if we
+ * are offline
+ *
+ * Return what the host did, or the ID of the pending operation and the
+ * network error that made us store it (nil with --offline)
+ */
+func sendIssueOperation(ctx context.Context, ad ArgumentData,
+	op TPendingOp) (result string, pendingID uint, neterr error) {
+
+	return sendIssueOperationWith(ctx, ad, nil, op)
+}
+
+/* Like sendIssueOperation(), but use the host 'r' if it's not nil, like
+ * loadIssuesWith() does
+ */
+func sendIssueOperationWith(ctx context.Context, ad ArgumentData, r TRepoHost,
+	op TPendingOp) (result string, pendingID uint, neterr error) {
+
+	if !ad.offline {
+		var err error
+		if r == nil {
+			r, err = findRepositoryHost(ctx, ad.auth)
+		}
+		if err == nil {
+			if result, err = applyPendingOp(ctx, r, ad.auth, op); err == nil {
+				return result, 0, nil
+			}
+		}
+
+		if !isNetworkError(err) {
+			panic(err)
+		}
+		neterr = err
+	}
+
+	// Remember how the issue was, as far as we know
+	if op.Kind != opNewIssue {
+		if cache, err := openIssueCache(getCurrentRepository()); err == nil {
+			if issue, _ := cache.getIssue(op.Issue); issue != nil {
+				op.BaseUpdated = issue.updated
+				op.BaseClosed = issue.is_closed
+			}
+		}
+	}
+
+	journal, err := openPendingJournal()
+	if err != nil {
+		panic(err)
+	}
+
+	pendingID = journal.add(op)
+	if err := journal.save(); err != nil {
+		panic(err)
+	}
+
+	return "", pendingID, neterr
+}
+
+/* Send the operation with sendIssueOperation(), and tell the user what
+ * happened
+ */
+func runIssueOperation(ctx context.Context, ad ArgumentData, op TPendingOp) {
+	result, id, neterr := sendIssueOperation(ctx, ad, op)
+	if id == 0 {
+		if isStructuredFormat(ad.format) {
+			printStructured(ad.format, TJSONOperation{Kind: op.Kind,
+				Issue: op.Issue, Status: "done", Message: result})
+			return
+		}
+
+		fmt.Println(result)
+		return
+	}
+
+	if neterr != nil {
+		fmt.Fprintf(os.Stderr, "Could not reach the repository host (%s)\n",
+			neterr.Error())
+	}
+
+	if isStructuredFormat(ad.format) {
+		printStructured(ad.format, TJSONOperation{ID: id, Kind: op.Kind,
+			Issue: op.Issue, Status: "pending", Message: op.describe()})
+		return
+	}
+
+	fmt.Printf("Saved %s as pending operation %d.\n", op.describe(), id)
+	fmt.Println("Run 'shissue push' when you are online to send it")
+}
+
+/* Parse the issue number in 'args[1]' */
+func parseIssueNumber(args []string) uint {
+	if len(args) < 2 {
+		panic("Issue number not specified!")
+	}
+
+	issuen, err := strconv.ParseUint(strings.TrimPrefix(args[1], "#"), 10, 32)
+	if err != nil {
+		panic("Invalid issue number: " + args[1])
+	}
+
+	return uint(issuen)
+}
+
+/* Get the text given with '-m <text>' in 'args', or ask the user for it in
+ * their editor if there isn't one
+ * 'what' is what the text is, for the editor help text
+ */
+func getMessageArg(args []string, what string) string {
+	for idx, arg := range args {
+		if arg == "-m" || arg == "--message" {
+			if len(args) <= idx+1 {
+				panic("Message not specified!")
+			}
+			return args[idx+1]
+		}
+	}
+
+	text, err := editText("\n# Write the " + what + " above.\n" +
+		"# Lines starting with '#' will be ignored, and an empty text " +
+		"aborts.\n")
+	if err != nil {
+		panic(err)
+	}
+
+	if text == "" {
+		panic("Aborting due to empty " + what)
+	}
+
+	return text
+}
+
+func _newIssue(ctx context.Context, ad ArgumentData, args []string) {
+	if len(args) < 2 || args[1] == "help" {
+		fmt.Println(args[0] + " <title> [-m <description>] [-l <label1,[label2...]>]")
+		fmt.Println(" Create an issue. If no description is given, your editor is opened")
+		fmt.Println()
+		return
+	}
+
+	op := TPendingOp{Kind: opNewIssue, Title: args[1]}
+	for idx, arg := range args {
+		if (arg == "-l" || arg == "--labels") && len(args) > idx+1 {
+			for _, label := range strings.Split(args[idx+1], ",") {
+				op.Labels = append(op.Labels, strings.TrimSpace(label))
+			}
+		}
+	}
+
+	op.Content = getMessageArg(args, "issue description")
+	runIssueOperation(ctx, ad, op)
+}
+
+func _commentIssue(ctx context.Context, ad ArgumentData, args []string) {
+	if len(args) < 2 || args[1] == "help" {
+		fmt.Println(args[0] + " <issue_num> [-m <comment>]")
+		fmt.Println(" Comment on an issue. If no comment is given, your editor is opened")
+		fmt.Println()
+		return
+	}
+
+	op := TPendingOp{Kind: opComment, Issue: parseIssueNumber(args)}
+	op.Content = getMessageArg(args, "comment")
+	runIssueOperation(ctx, ad, op)
+}
+
+func _closeIssue(ctx context.Context, ad ArgumentData, args []string) {
+	if len(args) < 2 || args[1] == "help" {
+		fmt.Println(args[0] + " <issue_num>")
+		fmt.Println(" Close (or reopen) an issue")
+		fmt.Println()
+		return
+	}
+
+	kind := opClose
+	if args[0] == "reopen" {
+		kind = opReopen
+	}
+
+	runIssueOperation(ctx, ad, TPendingOp{Kind: kind,
+		Issue: parseIssueNumber(args)})
+}
+
+func _labelIssue(ctx context.Context, ad ArgumentData, args []string) {
+	if len(args) < 3 || args[1] == "help" {
+		fmt.Println(args[0] + " <issue_num> [+]<label> [-<label>...]")
+		fmt.Println(" Add labels to an issue, or remove the ones starting with '-'")
+		fmt.Println()
+		return
+	}
+
+	op := TPendingOp{Kind: opLabel, Issue: parseIssueNumber(args)}
+	for _, arg := range args[2:] {
+		if strings.HasPrefix(arg, "-") {
+			op.RemoveLabels = append(op.RemoveLabels, arg[1:])
+		} else {
+			op.Labels = append(op.Labels, strings.TrimPrefix(arg, "+"))
+		}
+	}
+
+	runIssueOperation(ctx, ad, op)
+}
+
+func _pushPending(ctx context.Context, ad ArgumentData, args []string) {
+	journal, err := openPendingJournal()
+	if err != nil {
+		panic(err)
+	}
+
+	force := false
+	if len(args) > 1 {
+		switch args[1] {
+		case "help":
+			fmt.Println(args[0] + " [list|drop <id>|--force]")
+			fmt.Println(" Send the operations made while offline to the host")
+			fmt.Println()
+			fmt.Println(" Operations on issues that were changed since they were made are")
+			fmt.Println(" not sent, and are kept for you to decide what to do. Send them")
+			fmt.Println(" anyway with --force, or discard them with 'drop <id>'")
+			fmt.Println()
+			return
+
+		case "list":
+			if isStructuredFormat(ad.format) {
+				list := newStructuredList(ad.format)
+				for _, op := range journal.Ops {
+					list.add(op)
+				}
+				list.finish()
+				return
+			}
+
+			if len(journal.Ops) == 0 {
+				fmt.Println("No pending operations")
+			}
+			for _, op := range journal.Ops {
+				fmt.Printf(" %3d  %-40s (%s)\n", op.ID, op.describe(),
+					relativeTime(op.Created))
+			}
+			return
+
+		case "drop":
+			if len(args) < 3 {
+				panic("Operation ID not specified!")
+			}
+
+			id, err := strconv.ParseUint(args[2], 10, 32)
+			if err != nil || !journal.remove(uint(id)) {
+				panic("No pending operation with ID " + args[2])
+			}
+
+			if err := journal.save(); err != nil {
+				panic(err)
+			}
+			return
+
+		case "--force":
+			force = true
+		}
+	}
+
+	structured := isStructuredFormat(ad.format)
+	if len(journal.Ops) == 0 {
+		if structured {
+			newStructuredList(ad.format).finish()
+			return
+		}
+
+		fmt.Println("No pending operations")
+		return
+	}
+
+	r := getRepositoryHost(ctx, ad.auth)
+
+	// Print each operation result as it happens
+	var results *TStructuredList
+	if structured {
+		results = newStructuredList(ad.format)
+	}
+
+	// Send the operations in the order they were made. Remove each one as
+	// soon as it's sent, so a failure in the middle doesn't send it twice
+	//
+	// Issues we already changed here were checked for conflicts in their
+	// first operation. Our own changes are not conflicts
+	conflicts := 0
+	touched := make(map[uint]bool)
+	for _, op := range append([]TPendingOp(nil), journal.Ops...) {
+		if !force && !touched[op.Issue] {
+			conflict, err := checkPendingOpConflict(ctx, r, ad.auth, op)
+			if err != nil {
+				panic(err)
+			}
+
+			if conflict != "" {
+				if structured {
+					results.add(TJSONOperation{ID: op.ID, Kind: op.Kind,
+						Issue: op.Issue, Status: "conflict", Message: conflict})
+				} else {
+					fmt.Printf(" %3d  %s: conflict, %s\n", op.ID,
+						op.describe(), conflict)
+				}
+				conflicts++
+				continue
+			}
+		}
+
+		result, err := applyPendingOp(ctx, r, ad.auth, op)
+		if err != nil {
+			panic(err)
+		}
+
+		if structured {
+			results.add(TJSONOperation{ID: op.ID, Kind: op.Kind,
+				Issue: op.Issue, Status: "done", Message: result})
+		} else {
+			fmt.Printf(" %3d  %s\n", op.ID, result)
+		}
+
+		if op.Kind != opNewIssue {
+			touched[op.Issue] = true
+		}
+
+		journal.remove(op.ID)
+		if err := journal.save(); err != nil {
+			panic(err)
+		}
+	}
+
+	if structured {
+		results.finish()
+		return
+	}
+
+	if conflicts > 0 {
+		fmt.Printf("\n%d operations were not sent because of conflicts.\n", conflicts)
+		fmt.Println("Check the issues, then run 'shissue push --force' to send them " +
+			"anyway, or 'shissue push drop <id>' to discard them")
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+/**
+ * Label management
+ *
+ * The 'labels' command lists the labels of the repository, creates,
+ * renames, recolors and deletes them, and adds them to (or removes them
+ * from) issues.
+ *
+ * Copyright (C) 2018 Arthur M
+ */
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+/* Color of new labels, when none is given. The same Github uses */
+const defaultLabelColor = "ededed"
+
+/* Hex colors, like 'ff0000', '#ff0000' or 'f00' */
+var labelColorRegex = regexp.MustCompile(`^#?([0-9A-Fa-f]{6}|[0-9A-Fa-f]{3})$`)
+
+/* Parse the label color 'color', and return it as 6 hex digits */
+func parseLabelColor(color string) string {
+	if !labelColorRegex.MatchString(color) {
+		panic("Invalid color " + color + ". Use an hex color, like 'ff0000' or '#f00'")
+	}
+
+	color = strings.ToLower(strings.TrimPrefix(color, "#"))
+	if len(color) == 3 {
+		color = string([]byte{color[0], color[0], color[1], color[1],
+			color[2], color[2]})
+	}
+
+	return color
+}
+
+/* Find the label named 'name' in 'labels', ignoring case like the hosts
+ * do. Return nil if there's none
+ */
+func findRepoLabel(labels []TRepoLabel, name string) *TRepoLabel {
+	for idx := range labels {
+		if strings.EqualFold(labels[idx].name, name) {
+			return &labels[idx]
+		}
+	}
+
+	return nil
+}
+
+/* Get the repository host, to change its labels or milestones ('what').
+ * They can't be changed offline, since the changes would affect every issue
+ */
+func getManagementHost(ctx context.Context, ad ArgumentData, what string) TRepoHost {
+	if ad.offline {
+		panic(what + " can't be managed offline")
+	}
+
+	r, err := findRepositoryHost(ctx, ad.auth)
+	if err != nil {
+		panic(err)
+	}
+
+	return r
+}
+
+/* Download the labels, and get the one named 'name' from them */
+func getRepoLabel(ctx context.Context, ad ArgumentData, r TRepoHost, name string) TRepoLabel {
+	labels, err := r.DownloadLabels(ctx, ad.auth)
+	if err != nil {
+		panic(err)
+	}
+
+	label := findRepoLabel(labels, name)
+	if label == nil {
+		panic("No label named " + name)
+	}
+
+	return *label
+}
+
+/* Tell the user that 'message' was done, in the output format */
+func printManagementResult(ad ArgumentData, kind, message string) {
+	if isStructuredFormat(ad.format) {
+		printStructured(ad.format, TJSONOperation{Kind: kind, Status: "done",
+			Message: message})
+		return
+	}
+
+	fmt.Println(message)
+}
+
+/* Print the labels of the repository, with their colors */
+func printRepoLabels(ad ArgumentData, labels []TRepoLabel) {
+	if isStructuredFormat(ad.format) {
+		list := newStructuredList(ad.format)
+		for _, label := range labels {
+			list.add(TJSONLabel{Name: label.name, Color: label.hexColor(),
+				Description: label.description})
+		}
+		list.finish()
+		return
+	}
+
+	width := 0
+	for _, label := range labels {
+		if w := visibleWidth(label.name); w > width {
+			width = w
+		}
+	}
+
+	for _, label := range labels {
+		line := "  " + labelChip(label.TIssueLabel)
+		if label.description != "" {
+			line += strings.Repeat(" ", width-visibleWidth(label.name)) +
+				"  " + ansiStyle("2", label.description)
+		}
+		fmt.Println(line)
+	}
+}
+
+/* Get the value of the option 'name' in 'args', like '--color <color>'
+ * Return 'def' if it isn't there
+ */
+func getOptionArg(args []string, name, def string) string {
+	for idx, arg := range args {
+		if arg == name {
+			if idx+1 >= len(args) {
+				panic("Value of " + name + " not specified!")
+			}
+			return args[idx+1]
+		}
+	}
+
+	return def
+}
+
+func _labels(ctx context.Context, ad ArgumentData, args []string) {
+	command := "list"
+	if len(args) > 1 {
+		command = args[1]
+	}
+
+	switch command {
+	case "help":
+		fmt.Println(args[0] + " [list]")
+		fmt.Println(" List the labels of the repository")
+		fmt.Println(args[0] + " create <name> [--color <color>] [--description <text>]")
+		fmt.Println(" Create a label. The color is an hex color, like 'ff0000' or '#f00'")
+		fmt.Println(args[0] + " rename <name> <new_name>")
+		fmt.Println(args[0] + " recolor <name> <color>")
+		fmt.Println(args[0] + " delete <name> [--yes]")
+		fmt.Println(" Delete a label, removing it from every issue. Asks first, unless")
+		fmt.Println(" --yes is given")
+		fmt.Println(args[0] + " add|remove <issue_num> <label> [<label>...]")
+		fmt.Println(" Add labels to an issue, or remove them from it")
+		fmt.Println(args[0] + " sync <file> [--dry-run] [--prune] [--yes]")
+		fmt.Println(" Make the labels be the ones in a JSON or YAML file, with a name,")
+		fmt.Println(" color, description and aliases (old names, to rename) for each.")
+		fmt.Println(" Shows what will change, and asks first unless --yes is given.")
+		fmt.Println(" --prune deletes the labels that aren't in the file")
+		fmt.Println()
+
+	case "list":
+		if isTableFormat(ad.format) || ad.template != nil {
+			panic("The labels can only be printed as text, json or ndjson")
+		}
+
+		labels, err := getManagementHost(ctx, ad, "Labels").DownloadLabels(ctx, ad.auth)
+		if err != nil {
+			panic(err)
+		}
+
+		startPager(ad)
+		printRepoLabels(ad, labels)
+
+	case "create":
+		if len(args) < 3 {
+			panic("Label name not specified!")
+		}
+
+		label := TRepoLabel{
+			TIssueLabel: newIssueLabel(args[2], parseLabelColor(
+				getOptionArg(args[3:], "--color", defaultLabelColor))),
+			description: getOptionArg(args[3:], "--description", ""),
+		}
+
+		if err := getManagementHost(ctx, ad, "Labels").CreateLabel(ctx, ad.auth, label); err != nil {
+			panic(err)
+		}
+		printManagementResult(ad, "create-label", "Created the label "+
+			labelChip(label.TIssueLabel))
+
+	case "rename", "recolor":
+		if len(args) < 4 {
+			panic("Label name and new " + map[string]string{
+				"rename": "name", "recolor": "color"}[command] + " not specified!")
+		}
+
+		r := getManagementHost(ctx, ad, "Labels")
+		label := getRepoLabel(ctx, ad, r, args[2])
+		name := label.name
+
+		if command == "rename" {
+			label.name = args[3]
+		} else {
+			label.TIssueLabel = newIssueLabel(label.name, parseLabelColor(args[3]))
+		}
+
+		if err := r.EditLabel(ctx, ad.auth, name, label); err != nil {
+			panic(err)
+		}
+
+		if command == "rename" {
+			printManagementResult(ad, "rename-label", "Renamed the label "+name+
+				" to "+labelChip(label.TIssueLabel))
+		} else {
+			printManagementResult(ad, "recolor-label", "Changed the color of "+
+				labelChip(label.TIssueLabel))
+		}
+
+	case "delete":
+		if len(args) < 3 {
+			panic("Label name not specified!")
+		}
+
+		r := getManagementHost(ctx, ad, "Labels")
+		label := getRepoLabel(ctx, ad, r, args[2])
+
+		if !containsFold(args[3:], "--yes") && !askConfirmation("Delete the label "+
+			labelChip(label.TIssueLabel)+"? It will be removed from every issue.") {
+			panic("Not deleting the label. Use --yes to delete it without asking")
+		}
+
+		if err := r.DeleteLabel(ctx, ad.auth, label.name); err != nil {
+			panic(err)
+		}
+		printManagementResult(ad, "delete-label", "Deleted the label "+label.name)
+
+	case "add", "remove":
+		if len(args) < 4 {
+			panic("Issue number and labels not specified!")
+		}
+
+		op := TPendingOp{Kind: opLabel, Issue: parseIssueNumber(args[1:])}
+		if command == "add" {
+			op.Labels = args[3:]
+		} else {
+			op.RemoveLabels = args[3:]
+		}
+		runIssueOperation(ctx, ad, op)
+
+	case "sync":
+		syncLabels(ctx, ad, args[2:])
+
+	default:
+		panic("Unknown labels command " + command)
+	}
+}
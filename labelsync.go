@@ -0,0 +1,489 @@
+package main
+
+/**
+ * Declarative label sync
+ *
+ * 'labels sync <file>' makes the labels of the repository be the ones in
+ * a file, so the same labels can be kept in many repositories. The file is
+ * JSON or YAML, like this:
+ *
+ *   labels:
+ *     - name: bug
+ *       color: "d73a4a"
+ *       description: Something isn't working
+ *       aliases: [defect, "type: bug"]
+ *
+ * Labels named like an alias are renamed, so the issues keep them. What
+ * will change is always shown first.
+ *
+ * Copyright (C) 2018 Arthur M
+ */
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+/* A label, as the label file defines it
+ * A nil color or description means "keep the one the label has"
+ */
+type TLabelDefinition struct {
+	Name        string   `json:"name"`
+	Color       *string  `json:"color"`
+	Description *string  `json:"description"`
+	Aliases     []string `json:"aliases"` // Old names, to rename
+}
+
+/* A change 'labels sync' makes */
+type TLabelChange struct {
+	action string     // 'create', 'rename', 'update' or 'delete'
+	from   TRepoLabel // The label as it is, except for 'create'
+	to     TRepoLabel // The label as it will be, except for 'delete'
+}
+
+/* Remove the comment of a YAML line, if any. A comment starts with a '#'
+ * at the start of the line or after a space, and not inside quotes
+ */
+func stripYAMLComment(line string) string {
+	var quote rune
+	for idx, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#' && (idx == 0 || line[idx-1] == ' ' || line[idx-1] == '\t'):
+			return line[:idx]
+		}
+	}
+
+	return line
+}
+
+/* Get the value of the YAML scalar 's', without its quotes */
+func parseYAMLScalar(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return strconv.Unquote(s)
+	}
+
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1), nil
+	}
+
+	return s, nil
+}
+
+/* Parse the YAML flow list 's', like '[a, "b, c"]' */
+func parseYAMLFlowList(s string) ([]string, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimSpace(s[1 : len(s)-1])
+	if s == "" {
+		return nil, nil
+	}
+
+	items := make([]string, 0)
+	var quote rune
+	start := 0
+	for idx, r := range s + "," {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == ',':
+			item, err := parseYAMLScalar(s[start:idx])
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			start = idx + 1
+		}
+	}
+
+	return items, nil
+}
+
+/* Parse a label file in YAML
+ *
+ * It isn't a full YAML parser: it knows a list of labels (at the top, or
+ * under 'labels:') with plain values, and lists of aliases
+ */
+func parseLabelYAML(text string) ([]TLabelDefinition, error) {
+	defs := make([]TLabelDefinition, 0)
+	var current *TLabelDefinition
+	itemIndent := -1  // Indentation of the '-' of the labels
+	aliasIndent := -1 // Indentation of 'aliases:', when in an alias block
+
+	for lineno, line := range strings.Split(text, "\n") {
+		lineError := func(msg string) error {
+			return fmt.Errorf("line %d: %s", lineno+1, msg)
+		}
+
+		line = strings.TrimRight(stripYAMLComment(line), " \t\r")
+		content := strings.TrimLeft(line, " ")
+		indent := len(line) - len(content)
+		if content == "" || content == "---" {
+			continue
+		}
+
+		if strings.HasPrefix(content, "\t") {
+			return nil, lineError("tabs can't be used for indentation")
+		}
+
+		if indent == 0 && content == "labels:" {
+			continue
+		}
+
+		isItem := content == "-" || strings.HasPrefix(content, "- ")
+
+		// An item of an alias block
+		if isItem && aliasIndent >= 0 && indent > aliasIndent {
+			alias, err := parseYAMLScalar(strings.TrimPrefix(content, "-"))
+			if err != nil {
+				return nil, lineError(err.Error())
+			}
+			current.Aliases = append(current.Aliases, alias)
+			continue
+		}
+		aliasIndent = -1
+
+		if isItem {
+			if itemIndent < 0 {
+				itemIndent = indent
+			}
+			if indent != itemIndent {
+				return nil, lineError("unexpected list item")
+			}
+
+			defs = append(defs, TLabelDefinition{})
+			current = &defs[len(defs)-1]
+
+			// The first key can be in the same line as the '-'
+			content = strings.TrimSpace(strings.TrimPrefix(content, "-"))
+			indent += 2
+			if content == "" {
+				continue
+			}
+		}
+
+		if current == nil || indent <= itemIndent {
+			return nil, lineError("expected a label, starting with '-'")
+		}
+
+		colon := strings.Index(content, ":")
+		if colon < 0 {
+			return nil, lineError("expected 'key: value'")
+		}
+
+		key := strings.TrimSpace(content[:colon])
+		rawValue := strings.TrimSpace(content[colon+1:])
+
+		if key == "aliases" {
+			if rawValue == "" {
+				aliasIndent = indent
+				continue
+			}
+
+			if !strings.HasPrefix(rawValue, "[") || !strings.HasSuffix(rawValue, "]") {
+				return nil, lineError("aliases must be a list")
+			}
+
+			aliases, err := parseYAMLFlowList(rawValue)
+			if err != nil {
+				return nil, lineError(err.Error())
+			}
+			current.Aliases = append(current.Aliases, aliases...)
+			continue
+		}
+
+		value, err := parseYAMLScalar(rawValue)
+		if err != nil {
+			return nil, lineError(err.Error())
+		}
+
+		switch key {
+		case "name":
+			current.Name = value
+		case "color":
+			if value == "" {
+				return nil, lineError("empty color. Colors starting with '#' " +
+					"need quotes, or YAML thinks they are comments")
+			}
+			current.Color = &value
+		case "description":
+			current.Description = &value
+		default:
+			return nil, lineError("unknown key " + key)
+		}
+	}
+
+	return defs, nil
+}
+
+/* Read the label definitions in the file 'path', in JSON or in YAML
+ * JSON files can have the label list, or an object with it in 'labels'
+ */
+func readLabelFile(path string) ([]TLabelDefinition, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// A misspelled key would be ignored, and the labels changed anyway
+	decodeJSON := func(v interface{}) error {
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.DisallowUnknownFields()
+		return decoder.Decode(v)
+	}
+
+	var defs []TLabelDefinition
+	text := strings.TrimSpace(string(content))
+	switch {
+	case strings.HasPrefix(text, "["):
+		err = decodeJSON(&defs)
+	case strings.HasPrefix(text, "{"):
+		var file struct {
+			Labels []TLabelDefinition `json:"labels"`
+		}
+		err = decodeJSON(&file)
+		defs = file.Labels
+	default:
+		defs, err = parseLabelYAML(string(content))
+	}
+
+	if err != nil {
+		return nil, errors.New(path + ": " + err.Error())
+	}
+
+	// Check everything before we change anything
+	names := make([]string, 0, len(defs))
+	for _, def := range defs {
+		if def.Name == "" {
+			return nil, errors.New(path + ": there's a label without a name")
+		}
+
+		for _, name := range append([]string{def.Name}, def.Aliases...) {
+			if containsFold(names, name) {
+				return nil, errors.New(path + ": " + name + " is there twice")
+			}
+			names = append(names, name)
+		}
+
+		if def.Color != nil && !labelColorRegex.MatchString(*def.Color) {
+			return nil, errors.New(path + ": invalid color " + *def.Color +
+				" in " + def.Name)
+		}
+	}
+
+	return defs, nil
+}
+
+/* Find what has to change to make the labels 'current' be the ones in
+ * 'defs'. With 'prune', the labels not in 'defs' are deleted
+ * Return the changes, and how many labels are already right
+ */
+func planLabelSync(current []TRepoLabel, defs []TLabelDefinition,
+	prune bool) ([]TLabelChange, int) {
+
+	changes := make([]TLabelChange, 0)
+	upToDate := 0
+	used := make(map[string]bool) // Current labels we took care of
+
+	for _, def := range defs {
+		existing := findRepoLabel(current, def.Name)
+		if existing == nil {
+			for _, alias := range def.Aliases {
+				if existing = findRepoLabel(current, alias); existing != nil {
+					break
+				}
+			}
+		}
+
+		if existing == nil {
+			color := defaultLabelColor
+			if def.Color != nil {
+				color = parseLabelColor(*def.Color)
+			}
+
+			label := TRepoLabel{TIssueLabel: newIssueLabel(def.Name, color)}
+			if def.Description != nil {
+				label.description = *def.Description
+			}
+
+			changes = append(changes, TLabelChange{action: "create", to: label})
+			continue
+		}
+
+		used[strings.ToLower(existing.name)] = true
+
+		wanted := *existing
+		wanted.name = def.Name
+		if def.Color != nil {
+			wanted.TIssueLabel = newIssueLabel(def.Name, parseLabelColor(*def.Color))
+		}
+		if def.Description != nil {
+			wanted.description = *def.Description
+		}
+
+		// Changing only the case of the name is a rename too
+		switch {
+		case existing.name != wanted.name:
+			changes = append(changes, TLabelChange{action: "rename",
+				from: *existing, to: wanted})
+		case wanted != *existing:
+			changes = append(changes, TLabelChange{action: "update",
+				from: *existing, to: wanted})
+		default:
+			upToDate++
+		}
+	}
+
+	if prune {
+		for _, label := range current {
+			if !used[strings.ToLower(label.name)] {
+				changes = append(changes, TLabelChange{action: "delete",
+					from: label})
+			}
+		}
+	}
+
+	return changes, upToDate
+}
+
+/* Describe the change 'change', like a line of a diff */
+func describeLabelChange(change TLabelChange) string {
+	switch change.action {
+	case "create":
+		desc := ansiStyle("32", "+ create ") + labelChip(change.to.TIssueLabel)
+		if change.to.description != "" {
+			desc += " " + ansiStyle("2", change.to.description)
+		}
+		return desc
+
+	case "delete":
+		return ansiStyle("31", "- delete ") + labelChip(change.from.TIssueLabel)
+	}
+
+	action := "~ update "
+	if change.action == "rename" {
+		action = "~ rename "
+	}
+
+	parts := make([]string, 0, 3)
+	if change.from.name != change.to.name {
+		parts = append(parts, change.from.name+" -> "+change.to.name)
+	}
+	if change.from.hexColor() != change.to.hexColor() {
+		parts = append(parts, "color "+change.from.hexColor()+" -> "+
+			change.to.hexColor())
+	}
+	if change.from.description != change.to.description {
+		parts = append(parts, "description "+strconv.Quote(change.from.description)+
+			" -> "+strconv.Quote(change.to.description))
+	}
+
+	return ansiStyle("33", action) + labelChip(change.to.TIssueLabel) + " " +
+		strings.Join(parts, ", ")
+}
+
+/* Make the change 'change' in the repository host */
+func applyLabelChange(ctx context.Context, ad ArgumentData, r TRepoHost,
+	change TLabelChange) error {
+
+	switch change.action {
+	case "create":
+		return r.CreateLabel(ctx, ad.auth, change.to)
+	case "rename", "update":
+		return r.EditLabel(ctx, ad.auth, change.from.name, change.to)
+	case "delete":
+		return r.DeleteLabel(ctx, ad.auth, change.from.name)
+	}
+
+	return errors.New("Unknown label change " + change.action)
+}
+
+/* 'labels sync <file> [--dry-run] [--prune] [--yes]' */
+func syncLabels(ctx context.Context, ad ArgumentData, args []string) {
+	if len(args) < 1 {
+		panic("Label file not specified!")
+	}
+
+	defs, err := readLabelFile(args[0])
+	if err != nil {
+		panic(err)
+	}
+
+	dryRun := containsFold(args[1:], "--dry-run")
+	prune := containsFold(args[1:], "--prune")
+	yes := containsFold(args[1:], "--yes")
+
+	r := getManagementHost(ctx, ad, "Labels")
+	current, err := r.DownloadLabels(ctx, ad.auth)
+	if err != nil {
+		panic(err)
+	}
+
+	changes, upToDate := planLabelSync(current, defs, prune)
+
+	if isStructuredFormat(ad.format) {
+		list := newStructuredList(ad.format)
+		for _, change := range changes {
+			jchange := TJSONLabelChange{Action: change.action,
+				Name: change.to.name, Color: change.to.hexColor(),
+				Description: change.to.description}
+			if change.action == "delete" {
+				jchange.Name = change.from.name
+				jchange.Color = change.from.hexColor()
+			}
+			if change.action == "rename" {
+				jchange.OldName = change.from.name
+			}
+			if change.action != "create" && change.action != "delete" &&
+				change.from.hexColor() != change.to.hexColor() {
+				jchange.OldColor = change.from.hexColor()
+			}
+			list.add(jchange)
+		}
+		list.finish()
+	} else {
+		for _, change := range changes {
+			fmt.Println(describeLabelChange(change))
+		}
+		fmt.Printf("%d labels to change, %d already up to date\n",
+			len(changes), upToDate)
+	}
+
+	if dryRun || len(changes) == 0 {
+		return
+	}
+
+	if !yes && !askConfirmation("Change the labels?") {
+		panic("Not changing the labels. Use --yes to change them without asking")
+	}
+
+	for _, change := range changes {
+		if err := applyLabelChange(ctx, ad, r, change); err != nil {
+			name := change.from.name
+			if name == "" {
+				name = change.to.name
+			}
+			panic(fmt.Errorf("Could not %s the label %s: %s", change.action,
+				name, err.Error()))
+		}
+	}
+
+	if !isStructuredFormat(ad.format) {
+		fmt.Printf("Changed %d labels\n", len(changes))
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseLabelYAML(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    []string // 'name color description aliases', '-' for nil
+		wantErr string
+	}{
+		{
+			name: "under labels",
+			text: "labels:\n" +
+				"  - name: bug\n" +
+				"    color: \"d73a4a\"\n" +
+				"    description: Something isn't working\n",
+			want: []string{"bug d73a4a Something isn't working []"},
+		},
+		{
+			name: "at the top, no color",
+			text: "- name: docs # The documentation\n" +
+				"- name: 'won''t fix'\n" +
+				"  description: \"\"\n",
+			want: []string{"docs - - []", "won't fix - \"\" []"},
+		},
+		{
+			name: "flow aliases",
+			text: "- name: bug\n" +
+				"  aliases: [defect, \"type: bug\"]\n",
+			want: []string{"bug - - [defect type: bug]"},
+		},
+		{
+			name: "block aliases",
+			text: "labels:\n" +
+				"  -\n" +
+				"    name: bug\n" +
+				"    aliases:\n" +
+				"      - defect\n" +
+				"      - 'kind: bug'\n" +
+				"    color: \"#f00\"\n" +
+				"  - name: feature\n",
+			want: []string{"bug #f00 - [defect kind: bug]", "feature - - []"},
+		},
+		{
+			name:    "unknown key",
+			text:    "- name: bug\n  colour: red\n",
+			wantErr: "line 2: unknown key colour",
+		},
+		{
+			name:    "color as a comment",
+			text:    "- name: bug\n  color: #ff0000\n",
+			wantErr: "line 2: empty color",
+		},
+		{
+			name:    "key outside a label",
+			text:    "name: bug\n",
+			wantErr: "line 1: expected a label",
+		},
+		{
+			name:    "aliases not a list",
+			text:    "- name: bug\n  aliases: defect\n",
+			wantErr: "line 2: aliases must be a list",
+		},
+		{
+			name:    "tabs",
+			text:    "- name: bug\n\tcolor: red\n",
+			wantErr: "line 2: tabs",
+		},
+	}
+
+	optional := func(s *string) string {
+		if s == nil {
+			return "-"
+		}
+		if *s == "" {
+			return `""`
+		}
+		return *s
+	}
+
+	for _, test := range tests {
+		defs, err := parseLabelYAML(test.text)
+		if test.wantErr != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), test.wantErr) {
+				t.Errorf("%s: got error %v, want %q", test.name, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+
+		got := make([]string, 0, len(defs))
+		for _, def := range defs {
+			aliases := "[" + strings.Join(def.Aliases, " ") + "]"
+			got = append(got, strings.Join([]string{def.Name,
+				optional(def.Color), optional(def.Description), aliases}, " "))
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestPlanLabelSync(t *testing.T) {
+	repoLabel := func(name, color, description string) TRepoLabel {
+		return TRepoLabel{TIssueLabel: newIssueLabel(name, color),
+			description: description}
+	}
+	str := func(s string) *string {
+		return &s
+	}
+
+	current := []TRepoLabel{
+		repoLabel("Bug", "ff0000", ""),
+		repoLabel("defect", "00ff00", "Broken"),
+		repoLabel("enhancement", "0000ff", "New feature"),
+		repoLabel("wontfix", "ffffff", ""),
+	}
+
+	tests := []struct {
+		name     string
+		defs     []TLabelDefinition
+		prune    bool
+		want     []string // 'action from -> to color'
+		upToDate int
+	}{
+		{
+			name: "up to date, case insensitive",
+			defs: []TLabelDefinition{
+				{Name: "Bug", Color: str("FF0000")},
+				{Name: "enhancement"},
+			},
+			upToDate: 2,
+			want:     []string{},
+		},
+		{
+			name: "case only rename",
+			defs: []TLabelDefinition{{Name: "bug"}},
+			want: []string{"rename Bug -> bug ff0000"},
+		},
+		{
+			name: "alias",
+			defs: []TLabelDefinition{
+				{Name: "feature", Aliases: []string{"Enhancement"}},
+			},
+			want: []string{"rename enhancement -> feature 0000ff"},
+		},
+		{
+			name: "the name wins over the aliases",
+			defs: []TLabelDefinition{
+				{Name: "bug", Color: str("#f00"), Aliases: []string{"defect"}},
+			},
+			want: []string{"rename Bug -> bug ff0000"},
+		},
+		{
+			name: "create and update",
+			defs: []TLabelDefinition{
+				{Name: "docs"},
+				{Name: "defect", Description: str("Not working")},
+				{Name: "wontfix", Color: str("000")},
+			},
+			upToDate: 0,
+			want: []string{
+				"create  -> docs ededed",
+				"update defect -> defect 00ff00",
+				"update wontfix -> wontfix 000000",
+			},
+		},
+		{
+			name: "prune",
+			defs: []TLabelDefinition{
+				{Name: "bug", Aliases: []string{"defect"}},
+				{Name: "enhancement"},
+			},
+			prune:    true,
+			upToDate: 1,
+			want: []string{
+				"rename Bug -> bug ff0000",
+				"delete defect ->  ",
+				"delete wontfix ->  ",
+			},
+		},
+		{
+			name: "prune keeps the renamed aliases",
+			defs: []TLabelDefinition{
+				{Name: "broken", Aliases: []string{"defect"}},
+				{Name: "Bug"},
+				{Name: "enhancement"},
+				{Name: "wontfix"},
+			},
+			prune:    true,
+			upToDate: 3,
+			want:     []string{"rename defect -> broken 00ff00"},
+		},
+	}
+
+	for _, test := range tests {
+		changes, upToDate := planLabelSync(current, test.defs, test.prune)
+
+		got := make([]string, 0, len(changes))
+		for _, change := range changes {
+			color := ""
+			if change.action != "delete" {
+				color = change.to.hexColor()
+			}
+			got = append(got, change.action+" "+change.from.name+" -> "+
+				change.to.name+" "+color)
+		}
+
+		if !reflect.DeepEqual(got, test.want) || upToDate != test.upToDate {
+			t.Errorf("%s: got %q and %d up to date, want %q and %d", test.name,
+				got, upToDate, test.want, test.upToDate)
+		}
+	}
+}
@@ -6,14 +6,14 @@ package main
  */
 
 import (
-	"bufio"
-	"crypto/tls"
+	"context"
 	"fmt"
-	"net/http"
 	"os"
-	"os/exec"
+	"os/signal"
 	"strconv"
 	"strings"
+	"text/template"
+	"time"
 )
 
 /**
@@ -26,11 +26,22 @@ import (
  */
 
 type ArgumentData struct {
-	auth                *TAuthentication
-	allowUntrustedCerts bool
+	auth    *TAuthentication
+	tls     TTLSOptions   // Certificates to trust or present to the host
+	timeout time.Duration // Timeout for each request to the host
+	noCache bool          // Always go to the host, even if the cache is fresh
+	offline bool          // Only read from the cache, never go to the host
+	format  string        // Output format, like 'text' or 'json'
+	columns []string      // Columns of the csv and markdown formats
+	raw     bool          // Print issue bodies as they are, without rendering
+	color   string        // When to color the output: auto, always or never
+	noPager bool          // Never send the output through the pager
+
+	// Template to print issues with, instead of the built-in modes
+	template *template.Template
 }
 
-type CCommandFunc func(ArgumentData, []string)
+type CCommandFunc func(context.Context, ArgumentData, []string)
 type CCommand struct {
 	name     string
 	desc     string
@@ -39,6 +50,9 @@ type CCommand struct {
 
 var commands = make([]CCommand, 0)
 
+/* Subcommands of the 'issues' command, like 'issues new' */
+var issueCommands = make([]CCommand, 0)
+
 func printHelp() {
 	fmt.Println(" shissue - view github/gitlab issues in command line")
 	fmt.Println()
@@ -55,21 +69,52 @@ func printHelp() {
 	fmt.Println(" [-U|--username] <<username>>\n\tspecify the username used in your repo account")
 	fmt.Println(" [-P|--password] <<password>>\n\tspecify the password used in your repo account")
 	fmt.Println(" --allow-untrusted-certs\n\tAllow connecting to certificates not trusted by the system")
+	fmt.Println(" --ca-file <<file>>\n\ttrust the CA certificates in <<file>>, besides the system ones\n\t(default: git's http.sslCAInfo for the repository URL)")
+	fmt.Println(" --client-cert <<file>> [--client-key <<file>>]\n\tpresent a client certificate to the repository host\n\t(default: git's http.sslCert and http.sslKey for the repository URL)")
+	fmt.Println(" --color[=<<when>>]\n\tcolor the output 'always', 'never', or on 'auto' (default), when it\n\tgoes to a terminal and NO_COLOR isn't set")
+	fmt.Println(" --no-pager\n\tdo not send the output through the pager (shissue.pager, $PAGER or 'less -R')")
+	fmt.Println(" --no-cache\n\tdo not read issues from the local cache, even if it's fresh")
+	fmt.Println(" --format <<format>>\n\thow to print what commands output: 'text' (default), 'json',\n\tor 'ndjson' (one JSON object per line). Issue lists can also be\n\tprinted as 'csv' or 'markdown' tables")
+	fmt.Println(" --columns <<column1,[column2...]>>\n\tcolumns of the csv and markdown tables. Can be number, title, state,\n\tauthor, assignees, labels, created, updated and url (default: all but updated)")
+	fmt.Println(" --raw\n\tprint issue and comment bodies as they are, without rendering their Markdown")
+	fmt.Println(" --template <<template|name>>\n\tprint issues with a Go text/template, like '{{.Number}} {{.Title}}',\n\tor with the template named <<name>> in git config (shissue.template.<<name>>)\n\tor in ~/.config/shissue/templates/<<name>>.tmpl")
+	fmt.Println(" --offline\n\tonly show what was synced with 'sync', do not connect to the host.\n\tThis also happens when the host can't be reached")
+	fmt.Println(" --timeout <<duration>>\n\thow long to wait for each request to the repository host,\n\tlike '30s' or '2m' (default: 30s, 0 waits forever)")
 	fmt.Println()
 }
 
 /* Parse the arguments
  * Return the argument index of the subcommand
+ *
+ * The options come before the command, like in git. What comes after it
+ * belongs to the command, even if it looks like one of our options
  */
 func parseArgs(ad *ArgumentData) uint {
 
 	commandstart := uint(1)
-	for idx, par := range os.Args {
+	for idx := 1; idx < len(os.Args); idx = int(commandstart) {
+		par := os.Args[idx]
 		if par == "--allow-untrusted-certs" {
-			ad.allowUntrustedCerts = true
+			ad.tls.allowUntrustedCerts = true
 			commandstart = uint(idx + 1)
 		}
 
+		if par == "--ca-file" || par == "--client-cert" || par == "--client-key" {
+			if len(os.Args) <= idx+1 {
+				panic("File not specified for " + par)
+			}
+
+			switch par {
+			case "--ca-file":
+				ad.tls.caFile = os.Args[idx+1]
+			case "--client-cert":
+				ad.tls.clientCert = os.Args[idx+1]
+			case "--client-key":
+				ad.tls.clientKey = os.Args[idx+1]
+			}
+			commandstart = uint(idx + 2)
+		}
+
 		if par == "-U" || par == "--username" {
 			if len(os.Args) < int(idx+1) {
 				panic("Username not specified")
@@ -82,6 +127,99 @@ func parseArgs(ad *ArgumentData) uint {
 			commandstart = uint(idx + 2)
 		}
 
+		if par == "--color" || strings.HasPrefix(par, "--color=") {
+			mode := strings.TrimPrefix(strings.TrimPrefix(par, "--color"), "=")
+			if mode == "" {
+				mode = colorAlways
+			}
+
+			if mode != colorAuto && mode != colorAlways && mode != colorNever {
+				panic("Invalid color mode " + mode + ". Try auto, always or never")
+			}
+
+			ad.color = mode
+			commandstart = uint(idx + 1)
+		}
+
+		if par == "--no-pager" {
+			ad.noPager = true
+			commandstart = uint(idx + 1)
+		}
+
+		if par == "--no-cache" {
+			ad.noCache = true
+			commandstart = uint(idx + 1)
+		}
+
+		if par == "--raw" {
+			ad.raw = true
+			commandstart = uint(idx + 1)
+		}
+
+		if par == "--offline" {
+			ad.offline = true
+			commandstart = uint(idx + 1)
+		}
+
+		if par == "--format" {
+			if len(os.Args) <= idx+1 {
+				panic("Format not specified")
+			}
+
+			if !containsFold(outputFormats, os.Args[idx+1]) {
+				panic("Unknown format " + os.Args[idx+1] + ". Try one of: " +
+					strings.Join(outputFormats, ", "))
+			}
+
+			ad.format = strings.ToLower(os.Args[idx+1])
+			commandstart = uint(idx + 2)
+		}
+
+		if par == "--columns" {
+			if len(os.Args) <= idx+1 {
+				panic("Columns not specified")
+			}
+
+			ad.columns = parseTableColumns(os.Args[idx+1])
+			commandstart = uint(idx + 2)
+		}
+
+		if par == "--template" {
+			if len(os.Args) <= idx+1 {
+				panic("Template not specified")
+			}
+
+			text, err := resolveTemplate(os.Args[idx+1])
+			if err != nil {
+				panic(err)
+			}
+
+			ad.template, err = parseIssueTemplate(text)
+			if err != nil {
+				panic("Invalid template: " + err.Error())
+			}
+			commandstart = uint(idx + 2)
+		}
+
+		if par == "--timeout" {
+			if len(os.Args) <= idx+1 {
+				panic("Timeout not specified")
+			}
+
+			timeout, err := time.ParseDuration(os.Args[idx+1])
+			if err != nil {
+				// A plain number is a number of seconds
+				secs, serr := strconv.ParseUint(os.Args[idx+1], 10, 32)
+				if serr != nil {
+					panic("Invalid timeout: " + err.Error())
+				}
+				timeout = time.Duration(secs) * time.Second
+			}
+
+			ad.timeout = timeout
+			commandstart = uint(idx + 2)
+		}
+
 		if par == "-P" || par == "--password" {
 			if len(os.Args) < int(idx+1) {
 				panic("Password not specified")
@@ -94,6 +232,11 @@ func parseArgs(ad *ArgumentData) uint {
 			ad.auth.password = os.Args[idx+1]
 			commandstart = uint(idx + 2)
 		}
+
+		// Not an option, so it's the command
+		if commandstart == uint(idx) {
+			break
+		}
 	}
 
 	return commandstart
@@ -105,11 +248,87 @@ func main() {
 			function: _printHelp},
 		CCommand{name: "issues", desc: "List repository issues",
 			function: _printIssues},
+		CCommand{name: "sync", desc: "Update the local issue cache",
+			function: _syncIssues},
+		CCommand{name: "push", desc: "Send the issue changes made while offline",
+			function: _pushPending},
+		CCommand{name: "tui", desc: "Browse the issues in a full-screen view",
+			function: _runTUI},
+		CCommand{name: "browse", desc: "Open an issue or the repository in the web browser",
+			function: _browse},
+		CCommand{name: "hooks", desc: "Install git hooks that link commits to issues",
+			function: _hooks},
+		CCommand{name: "labels", desc: "List and manage the repository labels",
+			function: _labels},
+		CCommand{name: "milestones", desc: "List and manage the repository milestones",
+			function: _milestones},
+	)
+
+	issueCommands = append(issueCommands,
+		CCommand{name: "search", desc: "Search issue titles, contents and comments",
+			function: _searchIssues},
+		CCommand{name: "pick", desc: "Choose an issue interactively, and print its number",
+			function: _pickIssue},
+		CCommand{name: "new", desc: "Create an issue",
+			function: _newIssue},
+		CCommand{name: "comment", desc: "Comment on an issue",
+			function: _commentIssue},
+		CCommand{name: "close", desc: "Close an issue",
+			function: _closeIssue},
+		CCommand{name: "reopen", desc: "Reopen an issue",
+			function: _closeIssue},
+		CCommand{name: "relabel", desc: "Add or remove labels of an issue",
+			function: _labelIssue},
+		CCommand{name: "start", desc: "Create a branch to work on an issue",
+			function: _startIssue},
+		CCommand{name: "history", desc: "Show everything that happened to an issue",
+			function: _issueHistory},
 	)
 
+	// Cancel everything we are doing when the user presses Ctrl-C
+	// A second Ctrl-C quits right away, in case something doesn't listen
+	// to the cancellation. The pager gets the Ctrl-C too, but it doesn't
+	// quit, so we wait for it instead of leaving it on the terminal
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigch := make(chan os.Signal, 2)
+	signal.Notify(sigch, os.Interrupt)
+	go func() {
+		<-sigch
+		cancel()
+		<-sigch
+		stopPager()
+		restoreTerminal()
+		os.Exit(130)
+	}()
+
+	// Always give the terminal back the way we found it, even when we
+	// die. And don't dump a stack trace if we only died because the user
+	// asked us to stop.
+	defer func() {
+		stopPager()
+		restoreTerminal()
+		if r := recover(); r != nil {
+			if err, ok := r.(error); ok && isBrokenPipe(err) {
+				return
+			}
+
+			if ctx.Err() != nil {
+				fmt.Fprintln(os.Stderr, "\nInterrupted")
+				os.Exit(130)
+			}
+			panic(r)
+		}
+	}()
+
 	// Process general parameters
 	var ad ArgumentData
 	ad.auth = nil
+	ad.timeout = defaultTimeout
+	ad.format = formatText
+	ad.columns = defaultTableColumns
+	ad.color = colorAuto
 
 	// Get username and token from git configuration
 	username, _ := getGitProperty("shissue.username")
@@ -122,11 +341,15 @@ func main() {
 	}
 
 	commandstart := parseArgs(&ad)
-
-	if ad.allowUntrustedCerts == true {
-		http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	setColorMode(ad.color)
+	if ad.template != nil && ad.format != formatText {
+		panic("--template can't be used with --format " + ad.format)
 	}
 
+	setHTTPTimeout(ad.timeout)
+
+	setTLSOptions(ad.tls)
+
 	if len(os.Args) <= int(commandstart) {
 		// No subcommand called. Print help
 		printHelp()
@@ -135,29 +358,21 @@ func main() {
 	}
 
 	if ad.auth != nil && ad.auth.username != "" && ad.auth.password == "" {
-		// gets() is made in a java-like way. Congrats, Go!
-		reader := bufio.NewReader(os.Stdin)
 		fmt.Printf("Password for %s: ", ad.auth.username)
 
-		/* Disable echo for you to type password, then enable it */
-		raw := exec.Command("stty", "-echo")
-		raw.Stdin = os.Stdin
-		_ = raw.Run()
-
-		pwd, _ := reader.ReadString('\n')
-		raw = exec.Command("stty", "echo")
-		raw.Stdin = os.Stdin
-		_ = raw.Run()
+		pwd, err := readPassword(ctx)
+		fmt.Println()
+		if err != nil {
+			panic(err)
+		}
 
-		pwd = strings.Trim(pwd, "\n\r")
 		ad.auth.password = pwd
-		fmt.Println()
 	}
 
 	// Check what command you want
 	for _, c := range commands {
 		if c.name == os.Args[commandstart] {
-			c.function(ad, os.Args[commandstart:])
+			c.function(ctx, ad, os.Args[commandstart:])
 			return
 		}
 	}
@@ -165,11 +380,21 @@ func main() {
 	fmt.Println("No command named " + os.Args[1])
 }
 
-func _printHelp(ad ArgumentData, args []string) {
+func _printHelp(ctx context.Context, ad ArgumentData, args []string) {
 	printHelp()
 }
 
-func _printIssues(ad ArgumentData, args []string) {
+func _printIssues(ctx context.Context, ad ArgumentData, args []string) {
+	// Check if you want an issue subcommand
+	if len(args) > 1 {
+		for _, c := range issueCommands {
+			if c.name == args[1] {
+				c.function(ctx, ad, args[1:])
+				return
+			}
+		}
+	}
+
 	printMode := "long"
 	if len(args) > 1 {
 		if args[1] == "long" || args[1] == "full" || args[1] == "short" || args[1] == "oneline" {
@@ -183,81 +408,79 @@ func _printIssues(ad ArgumentData, args []string) {
 		fmt.Println()
 		fmt.Println(" filters can be one or more of:")
 		fmt.Println(" \tlabels <label1,[label2...]> - Filter by labels")
+		fmt.Println(" \tno-label - Get only issues without labels")
 		fmt.Println(" \tassignee <assignee> - Filter by users that have an issue assigned to them")
+		fmt.Println(" \tno-assignee - Get only issues assigned to no one")
 		fmt.Println(" \tcreator <creator>  - Filter by issue creators,")
+		fmt.Println(" \tmentions <user> - Get only issues that mention <user>")
+		fmt.Println(" \tmilestone <name|none|any> - Filter by milestone, or get the issues")
+		fmt.Println(" \t                 without one ('none') or with any one ('any')")
+		fmt.Println(" \tupdated-since <date>, updated-until <date>, created-since <date> -")
+		fmt.Println(" \t                 Filter by dates. A date is a day (2018-12-31), a time")
+		fmt.Println(" \t                 (2018-12-31T15:04:05Z) or how long ago (12h, 3d, 2w)")
 		fmt.Println(" \t[open|closed|all] - Get only open, only closed or all issues")
+		fmt.Println(" \tsort <created|updated|comments> - Sort the issues (default: created)")
+		fmt.Println(" \tdirection <asc|desc> - Sort oldest (or least) first, or newest")
+		fmt.Println(" \t                 first (default)")
+		fmt.Println(" \t--limit <n> - Get at most <n> issues (default: get all of them)")
+		fmt.Println()
+		fmt.Println(args[0] + " <subcommand> [subcommandargs...]")
+		fmt.Println(" Search or change issues. When offline, the changes are saved to be")
+		fmt.Println(" sent later with 'push'. Subcommands:")
+		for _, c := range issueCommands {
+			fmt.Printf(" \t%-20s %s\n", c.name, c.desc)
+		}
 		fmt.Println()
 		return
 	}
 
-	r := getRepositoryHost(ad.auth)
-
 	fnBold := func(s string) string {
-		return "\033[37;1m" + s + "\033[0m"
+		return ansiStyle("37;1", s)
 	}
 
 	fnBoldYellow := func(s string) string {
-		return "\033[33;1m" + s + "\033[0m"
+		return ansiStyle("33;1", s)
 	}
 
 	fnBoldRed := func(s string) string {
-		return "\033[31;1m" + s + "\033[0m"
+		return ansiStyle("31;1", s)
 	}
 
 	fnYellow := func(s string) string {
-		return "\033[33m" + s + "\033[0m"
+		return ansiStyle("33", s)
 	}
 
 	fnBoldBlue := func(s string) string {
-		return "\033[36;1m" + s + "\033[0m"
+		return ansiStyle("36;1", s)
 	}
 
-	// Write the string 's' with a background color 'r','g','b'
-	// It will convert the color to a 256-color compatible one for printing
-	// to the terminal
-	// TODO: Check if 256 color is supported
-	// TODO: Print directly to the 24-bit color if supported
+	fnPrintBackColor := backColor
 
-	fnPrintBackColor := func(s string, r, g, b uint8) string { return s }
-	if os.Getenv("COLORTERM") == "truecolor" || os.Getenv("COLORTERM") == "24bit" {
-		fnPrintBackColor = func(s string, r, g, b uint8) string {
-			// (255 / 51 = 5, the number we have to limit it to convert the
-			cR, cG, cB := float32(r/51.0), float32(g/51.0), float32(b/51.0)
+	// If arg is a number, it might be the issue number
+	if len(args) > 1 {
+		if issuen, err := strconv.ParseUint(args[1], 10, 64); err == nil {
+			issue, icomments, host := loadIssueWith(ctx, ad, nil, uint(issuen))
 
-			if cR+cG*2.5+cB > 9.0 {
-				s = "\033[30m" + s
+			// Only the text view shows the references. Get them before
+			// the pager starts, since they might take a while
+			var refs []TIssueReference
+			if ad.format == formatText && ad.template == nil {
+				refs = loadIssueReferences(ctx, ad, host, issue.number)
 			}
-			return fmt.Sprintf("\033[48;2;%d;%d;%dm%s\033[0m",
-				r, g, b, s)
-		}
-	} else {
-		fnPrintBackColor = func(s string, r, g, b uint8) string {
-			// (255 / 51 = 5, the number we have to limit it to convert the
-			// number to a 256-color compatible one
-			cR, cG, cB := r/51, g/51, b/51
-
-			if cR+uint8(float32(cG)*2.5)+cB > 9 {
-				s = "\033[30m" + s
+			startPager(ad)
+			if isStructuredFormat(ad.format) {
+				printIssueStructured(ad.format, issue, icomments)
+				return
 			}
 
-			// taken from https://en.wikipedia.org/wiki/ANSI_escape_code#8-bit
-			cColorNum := 16 + 36*cR + 6*cG + cB
-
-			return "\033[48;5;" + strconv.Itoa(int(cColorNum)) +
-				"m" + s + "\033[0m"
-		}
-	}
-
-	// If arg is a number, it might be the issue number
-	if len(args) > 1 {
-		if issuen, err := strconv.ParseUint(args[1], 10, 64); err == nil {
-			issue, err := r.DownloadIssue(ad.auth, uint(issuen))
-			if err != nil {
-				panic(err)
+			if isTableFormat(ad.format) {
+				printIssuesTable(ad.format, ad.columns, []TIssue{*issue})
+				return
 			}
 
-			if issue == nil {
-				panic("No issue found with that number")
+			if ad.template != nil {
+				printIssueTemplate(ad.template, issue, icomments)
+				return
 			}
 
 			slabels := ""
@@ -283,31 +506,27 @@ func _printIssues(ad ArgumentData, args []string) {
 					", "))
 			}
 			fmt.Printf("\tAssigned to %s\n", strissue)
+			if issue.milestone != "" {
+				fmt.Printf("\tMilestone: %s\n", fnBold(issue.milestone))
+			}
 			if issue.is_closed {
 				fmt.Println("\tThis issue has been closed")
 			}
 
 			fmt.Println("\tView it online: " + issue.url)
 			fmt.Println()
-			fmt.Println(issue.content)
+			fmt.Println(formatBody(ad, issue.content, "", 0))
 			fmt.Println()
 
-			icomments, err := r.DownloadIssueComments(ad.auth,
-				uint(issuen))
-			if err != nil {
-				panic(err)
-			}
+			printIssueReferences(refs, findLinkedBranches(issue.number))
 
 			for _, comment := range icomments {
 				fmt.Printf("\t\t comment by "+
 					fnYellow("%s")+" in %v\n",
 					comment.author, comment.creation)
 
-				contentlines := strings.Split(comment.content, "\n")
-
-				for _, cline := range contentlines {
-					fmt.Println("\t\t\t" + cline)
-				}
+				// Three tabs, 8 columns each
+				fmt.Println(formatBody(ad, comment.content, "\t\t\t", 24))
 
 				fmt.Println()
 			}
@@ -316,73 +535,28 @@ func _printIssues(ad ArgumentData, args []string) {
 		}
 	}
 
-	filter := TIssueFilter{
-		labels:    nil,
-		assignee:  nil,
-		getOpen:   true,
-		getClosed: false,
-		creator:   nil,
-	}
-
 	// Create the filter structure
 	// Do not need to be done if you want to get a specific issue
-	if len(args) > 1 {
-		for idx, param := range args[1:] {
-			if param == "labels" || param == "label" {
-				// Get the labels
-				// They are comma-separated values
-				if len(args) < idx+1 {
-					panic("Label list not specified!")
-				}
-
-				labelarr := strings.Split(args[1+idx+1], ",")
-				labellist := make([]TIssueLabel, 0, len(labelarr))
-
-				for _, l := range labelarr {
-					labellist = append(labellist, TIssueLabel{
-						name: strings.Trim(l, " "),
-					})
-				}
-
-				filter.labels = &labellist
-				continue
-			}
-
-			if param == "assignee" {
-				// Get the assignee
-				if len(args) < idx+1 {
-					panic("Assignee not specified!")
-				}
-				filter.assignee = &args[1+idx+1]
-				continue
-			}
-
-			if param == "creator" {
-				// Get the assignee
-				if len(args) < idx+1 {
-					panic("Creator not specified!")
-				}
-				filter.creator = &args[1+idx+1]
-				continue
-			}
-
-			if param == "closed" {
-				filter.getClosed = true
-				filter.getOpen = false
-			}
+	filter := parseIssueFilter(args[1:])
 
-			if param == "all" {
-				filter.getClosed = true
-				filter.getOpen = true
-			}
+	// If not, it might be the type. Download everybody, then!
+	issues := loadIssues(ctx, ad, filter)
+	startPager(ad)
+	if isStructuredFormat(ad.format) {
+		printIssuesStructured(ad.format, issues)
+		return
+	}
 
-		}
+	if isTableFormat(ad.format) {
+		printIssuesTable(ad.format, ad.columns, issues)
+		return
 	}
 
-	// If not, it might be the type. Download everybody, then!
-	issues, err := r.DownloadAllIssues(ad.auth, filter)
-	if err != nil {
-		panic(err)
+	if ad.template != nil {
+		for idx := range issues {
+			printIssueTemplate(ad.template, &issues[idx], nil)
+		}
+		return
 	}
 
 	for _, issue := range issues {
@@ -422,7 +596,7 @@ func _printIssues(ad ArgumentData, args []string) {
 			}
 			fmt.Println("\tView it online: " + issue.url)
 			fmt.Println()
-			fmt.Println(issue.content)
+			fmt.Println(formatBody(ad, issue.content, "", 0))
 			fmt.Println("\n ")
 
 		} else if printMode == "oneline" || printMode == "short" {
@@ -437,3 +611,53 @@ func _printIssues(ad ArgumentData, args []string) {
 	}
 
 }
+
+/* Parse the issue filters in 'params', like 'labels bug,ui closed'
+ * Words that aren't filters are ignored, so the caller can have its own
+ */
+func parseIssueFilter(params []string) TIssueFilter {
+	filter := TIssueFilter{
+		labels:    nil,
+		assignee:  nil,
+		getOpen:   true,
+		getClosed: false,
+		creator:   nil,
+	}
+
+	for idx := 0; idx < len(params); idx++ {
+		param := params[idx]
+
+		if param == "--limit" {
+			// Get the maximum issue count
+			if len(params) <= idx+1 {
+				panic("Issue limit not specified!")
+			}
+
+			limit, err := strconv.ParseUint(params[idx+1], 10, 32)
+			if err != nil {
+				panic("Invalid issue limit: " + params[idx+1])
+			}
+			filter.limit = uint(limit)
+			idx++
+			continue
+		}
+
+		// Filters with a value, like 'assignee <user>'
+		if what, ok := issueFilterOptions[param]; ok {
+			if len(params) <= idx+1 {
+				panic(what + " not specified!")
+			}
+			setIssueFilterOption(&filter, param, params[idx+1])
+			idx++
+			continue
+		}
+
+		setIssueFilterFlag(&filter, param)
+	}
+
+	if filter.labels != nil && filter.noLabels {
+		panic("Can't filter by labels and by issues without labels at the same time")
+	}
+
+	return filter
+}
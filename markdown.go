@@ -0,0 +1,403 @@
+package main
+
+/**
+ * Markdown rendering for the terminal
+ *
+ * Issue and comment bodies are Markdown. Instead of printing the '**' and
+ * '###' as they are, we turn them into terminal styles, indent lists and
+ * code, wrap the text to the terminal width and move the link URLs to
+ * footnotes, so the text reads like it does in the browser.
+ *
+ * This isn't a complete Markdown parser. It knows what people usually
+ * write in issues, and prints everything else as it is.
+ *
+ * Like in the hosts, a new line in a paragraph is a line break.
+ *
+ * Copyright (C) 2018 Arthur M
+ */
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	mdHTMLComment = regexp.MustCompile(`(?s)<!--.*?-->`)
+	mdHeading     = regexp.MustCompile(`^(#{1,6})\s+(.*?)(\s+#+)?\s*$`)
+	mdRule        = regexp.MustCompile(`^([-*_])(\s*[-*_]){2,}$`)
+	mdSetextH1    = regexp.MustCompile(`^=+$`)
+	mdSetextH2    = regexp.MustCompile(`^-+$`)
+	mdQuote       = regexp.MustCompile(`^>\s?(.*)$`)
+	mdListItem    = regexp.MustCompile(`^(\s*)([-*+]|\d+[.)])\s+(.*)$`)
+	mdTask        = regexp.MustCompile(`^\[([ xX])\]\s+(.*)$`)
+
+	mdCodeSpan    = regexp.MustCompile("`+([^`]+)`+")
+	mdImage       = regexp.MustCompile(`!\[([^\]]*)\]\(([^)\s]+)(\s+"[^"]*")?\)`)
+	mdLink        = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)(\s+"[^"]*")?\)`)
+	mdAutoLink    = regexp.MustCompile(`<((https?|ftp|mailto):[^>\s]+)>`)
+	mdBold        = regexp.MustCompile(`\*\*([^*\s](.*?[^*\s])?)\*\*|\b__([^_\s](.*?[^_\s])?)__\b`)
+	mdItalic      = regexp.MustCompile(`\*([^*\s](.*?[^*\s])?)\*|\b_([^_\s](.*?[^_\s])?)_\b`)
+	mdStrike      = regexp.MustCompile(`~~([^~]+)~~`)
+	mdHTMLTag     = regexp.MustCompile(`(?i)</?(details|summary|p|div|sub|sup|kbd|b|i|em|strong|span)(\s[^>]*)?>`)
+	mdHTMLBreak   = regexp.MustCompile(`(?i)<br\s*/?>`)
+	mdEscapeChars = regexp.MustCompile(`\\([\\` + "`" + `*_{}\[\]()#+\-.!|>~])`)
+
+	ansiEscape = regexp.MustCompile("\033\\[[0-9;]*m")
+)
+
+/* A block of text we are rendering, like a paragraph or a list item */
+type TMarkdownBlock struct {
+	first string // Prefix of the first line
+	rest  string // Prefix of the other lines
+	style string // ANSI style of the whole block, if any
+	quote bool   // If the block is a quote
+	lines []string
+}
+
+type TMarkdownRenderer struct {
+	width     int
+	out       []string
+	block     *TMarkdownBlock
+	footnotes []string // Link URLs, in the order they appear
+}
+
+/* Get how many columns 's' takes in the terminal, not counting the
+ * ANSI escapes
+ */
+func visibleWidth(s string) int {
+	return utf8.RuneCountInString(ansiEscape.ReplaceAllString(s, ""))
+}
+
+/* Wrap 'text' to 'width' columns
+ * The first line starts with 'first', and the others with 'rest'
+ */
+func wrapText(text string, width int, first, rest string) []string {
+	lines := make([]string, 0)
+	line := first
+	linewidth := visibleWidth(first)
+	empty := true
+
+	for _, word := range strings.Fields(text) {
+		wordwidth := visibleWidth(word)
+		if !empty && linewidth+1+wordwidth > width {
+			lines = append(lines, line)
+			line, linewidth, empty = rest, visibleWidth(rest), true
+		}
+
+		if !empty {
+			line += " "
+			linewidth++
+		}
+		line += word
+		linewidth += wordwidth
+		empty = false
+	}
+
+	return append(lines, line)
+}
+
+/* Render a Markdown text for a terminal 'width' columns wide */
+func renderMarkdown(text string, width int) string {
+	r := &TMarkdownRenderer{width: width, out: make([]string, 0)}
+
+	text = mdHTMLComment.ReplaceAllString(text, "")
+	text = strings.Replace(text, "\r\n", "\n", -1)
+
+	fence := ""     // The fence of the code block we are in, if any
+	inList := false // If indented lines are part of a list item
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		if fence != "" {
+			if strings.HasPrefix(trimmed, fence) &&
+				strings.Trim(trimmed, fence[:1]) == "" {
+				fence = ""
+				continue
+			}
+
+			r.out = append(r.out, "    "+ansiStyle("36", expandTabs(line)))
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			r.flush()
+			fence = trimmed[:3]
+			continue
+		}
+
+		if trimmed == "" {
+			r.flush()
+			r.blankLine()
+			continue
+		}
+
+		// Indented code, unless it's part of a list item
+		if !inList && r.block == nil &&
+			(strings.HasPrefix(line, "    ") || strings.HasPrefix(line, "\t")) {
+			r.out = append(r.out, "    "+ansiStyle("36", expandTabs(line)))
+			continue
+		}
+
+		// Text underlined with '===' or '---' is a heading
+		if r.block != nil && !r.block.quote && r.block.first == "" &&
+			len(r.block.lines) == 1 &&
+			(mdSetextH1.MatchString(trimmed) || mdSetextH2.MatchString(trimmed)) {
+			level := 1
+			if trimmed[0] == '-' {
+				level = 2
+			}
+
+			heading := r.block.lines[0]
+			r.block = nil
+			r.heading(level, heading)
+			continue
+		}
+
+		if m := mdHeading.FindStringSubmatch(trimmed); m != nil {
+			r.flush()
+			r.heading(len(m[1]), m[2])
+			inList = false
+			continue
+		}
+
+		if mdRule.MatchString(trimmed) {
+			r.flush()
+			r.out = append(r.out, ansiStyle("2", strings.Repeat("─", r.width)))
+			inList = false
+			continue
+		}
+
+		if m := mdQuote.FindStringSubmatch(trimmed); m != nil {
+			if r.block == nil || !r.block.quote {
+				r.flush()
+				bar := ansiStyle("2", "│ ")
+				r.block = &TMarkdownBlock{first: bar, rest: bar, style: "2",
+					quote: true}
+			}
+			r.block.lines = append(r.block.lines, m[1])
+			continue
+		}
+
+		if m := mdListItem.FindStringSubmatch(expandTabs(line)); m != nil {
+			r.flush()
+			r.listItem(len(m[1])/2, m[2], m[3])
+			inList = true
+			continue
+		}
+
+		// Tables are fine as they are. Wrapping would break them
+		if strings.HasPrefix(trimmed, "|") {
+			r.flush()
+			r.out = append(r.out, r.inline(trimmed))
+			continue
+		}
+
+		if r.block == nil {
+			if inList && !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "\t") {
+				inList = false
+			}
+
+			indent := ""
+			if inList {
+				indent = strings.Repeat(" ", visibleWidth(expandTabs(line))-
+					visibleWidth(strings.TrimLeft(expandTabs(line), " ")))
+			}
+			r.block = &TMarkdownBlock{first: indent, rest: indent}
+		}
+		r.block.lines = append(r.block.lines, trimmed)
+	}
+
+	r.flush()
+
+	// No blank lines at the end, but the footnotes
+	for len(r.out) > 0 && r.out[len(r.out)-1] == "" {
+		r.out = r.out[:len(r.out)-1]
+	}
+
+	if len(r.footnotes) > 0 {
+		r.out = append(r.out, "")
+		for idx, url := range r.footnotes {
+			r.out = append(r.out, ansiStyle("2", "["+strconv.Itoa(idx+1)+"]: "+url))
+		}
+	}
+
+	return strings.Join(r.out, "\n")
+}
+
+/* Replace tabs by 4 spaces, so we can count columns */
+func expandTabs(s string) string {
+	return strings.Replace(s, "\t", "    ", -1)
+}
+
+/* Add a blank line, but never two in a row or at the start */
+func (r *TMarkdownRenderer) blankLine() {
+	if len(r.out) > 0 && r.out[len(r.out)-1] != "" {
+		r.out = append(r.out, "")
+	}
+}
+
+/* Write the pending block, wrapped */
+func (r *TMarkdownRenderer) flush() {
+	if r.block == nil {
+		return
+	}
+
+	for idx, line := range r.block.lines {
+		prefix := r.block.rest
+		if idx == 0 {
+			prefix = r.block.first
+		}
+
+		text := r.inline(line)
+		if r.block.style != "" {
+			// Styles inside the line reset the block style, so put it back
+			text = ansiStyle(r.block.style, strings.Replace(text, "\033[0m",
+				"\033[0m\033["+r.block.style+"m", -1))
+		}
+
+		r.out = append(r.out, wrapText(text, r.width, prefix, r.block.rest)...)
+	}
+
+	r.block = nil
+}
+
+func (r *TMarkdownRenderer) heading(level int, text string) {
+	r.blankLine()
+
+	style := "1"
+	switch level {
+	case 1:
+		style = "1;4"
+	case 2:
+		style = "1;33"
+	}
+
+	r.out = append(r.out, wrapText(ansiStyle(style, r.inline(text)), r.width,
+		"", "")...)
+	r.out = append(r.out, "")
+}
+
+/* Start a list item
+ * 'level' is how nested it is, 'marker' is the bullet or number in the
+ * text, and 'text' is what comes after it
+ */
+func (r *TMarkdownRenderer) listItem(level int, marker, text string) {
+	bullet := "•"
+	if marker[0] >= '0' && marker[0] <= '9' {
+		bullet = marker
+	}
+
+	// Task lists, like '- [x] Done'
+	if m := mdTask.FindStringSubmatch(text); m != nil {
+		bullet = "☐"
+		if m[1] != " " {
+			bullet = ansiStyle("32", "☑")
+		}
+		text = m[2]
+	}
+
+	indent := strings.Repeat("  ", level)
+	first := indent + bullet + " "
+	r.block = &TMarkdownBlock{
+		first: first,
+		rest:  strings.Repeat(" ", visibleWidth(first)),
+		lines: []string{text},
+	}
+}
+
+/* Get the footnote number of 'url', adding it if needed */
+func (r *TMarkdownRenderer) footnote(url string) string {
+	for idx, fnurl := range r.footnotes {
+		if fnurl == url {
+			return "[" + strconv.Itoa(idx+1) + "]"
+		}
+	}
+
+	r.footnotes = append(r.footnotes, url)
+	return "[" + strconv.Itoa(len(r.footnotes)) + "]"
+}
+
+/* Render the inline Markdown of a line: code, links, emphasis... */
+func (r *TMarkdownRenderer) inline(text string) string {
+	// Nothing inside code spans is Markdown, so render what is between them
+	result := ""
+	last := 0
+	for _, m := range mdCodeSpan.FindAllStringSubmatchIndex(text, -1) {
+		result += r.inlineText(text[last:m[0]]) +
+			ansiStyle("36", text[m[2]:m[3]])
+		last = m[1]
+	}
+
+	return result + r.inlineText(text[last:])
+}
+
+func (r *TMarkdownRenderer) inlineText(text string) string {
+	// Hide the escaped characters from the rules below, in the Unicode
+	// private use area, and bring them back at the end
+	text = mdEscapeChars.ReplaceAllStringFunc(text, func(s string) string {
+		return string(rune(0xE000) + rune(s[1]))
+	})
+
+	text = mdHTMLBreak.ReplaceAllString(text, " ")
+	text = mdHTMLTag.ReplaceAllString(text, "")
+
+	text = mdImage.ReplaceAllStringFunc(text, func(s string) string {
+		m := mdImage.FindStringSubmatch(s)
+		alt := m[1]
+		if alt == "" {
+			alt = "image"
+		}
+		return ansiStyle("2", "[image: "+alt+"]") + r.footnote(m[2])
+	})
+
+	text = mdLink.ReplaceAllStringFunc(text, func(s string) string {
+		m := mdLink.FindStringSubmatch(s)
+		if m[1] == m[2] {
+			return ansiStyle("4", m[2])
+		}
+		return ansiStyle("4", m[1]) + r.footnote(m[2])
+	})
+
+	text = mdAutoLink.ReplaceAllString(text, "$1")
+
+	text = mdBold.ReplaceAllStringFunc(text, func(s string) string {
+		return ansiStyle("1", s[2:len(s)-2])
+	})
+
+	text = mdItalic.ReplaceAllStringFunc(text, func(s string) string {
+		return ansiStyle("3", s[1:len(s)-1])
+	})
+
+	text = mdStrike.ReplaceAllStringFunc(text, func(s string) string {
+		return ansiStyle("9", s[2:len(s)-2])
+	})
+
+	return strings.Map(func(r rune) rune {
+		if r >= 0xE000 && r < 0xE080 {
+			return r - 0xE000
+		}
+		return r
+	}, text)
+}
+
+/* Format an issue or comment body to print it
+ * Every line starts with 'indent', which takes 'indentWidth' columns of the
+ * terminal. With --raw, the Markdown is printed as it is
+ */
+func formatBody(ad ArgumentData, text, indent string, indentWidth int) string {
+	if !ad.raw {
+		width := terminalWidth() - indentWidth
+		if width < 30 {
+			width = 30
+		}
+		text = renderMarkdown(text, width)
+	}
+
+	lines := strings.Split(text, "\n")
+	for idx := range lines {
+		lines[idx] = indent + lines[idx]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,248 @@
+package main
+
+/**
+ * Milestone management
+ *
+ * The 'milestones' command lists the milestones of the repository, with
+ * how many of their issues are done and when they are due, and creates,
+ * edits, closes and reopens them.
+ *
+ * Copyright (C) 2018 Arthur M
+ */
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* Find the milestone titled 'title' in 'milestones', ignoring case
+ * Return nil if there's none
+ */
+func findMilestone(milestones []TMilestone, title string) *TMilestone {
+	for idx := range milestones {
+		if strings.EqualFold(milestones[idx].title, title) {
+			return &milestones[idx]
+		}
+	}
+
+	return nil
+}
+
+/* Parse a due date, like '2018-12-31' */
+func parseDueDate(date string) time.Time {
+	due, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		panic("Invalid date " + date + ". Use YYYY-MM-DD, like 2018-12-31")
+	}
+
+	return due
+}
+
+/* Download the milestones, and get the one titled 'title' from them */
+func getMilestone(ctx context.Context, ad ArgumentData, r TRepoHost, title string) TMilestone {
+	milestones, err := r.DownloadMilestones(ctx, ad.auth, "all", false)
+	if err != nil {
+		panic(err)
+	}
+
+	milestone := findMilestone(milestones, title)
+	if milestone == nil {
+		panic("No milestone titled " + title)
+	}
+
+	return *milestone
+}
+
+/* Convert a milestone to the format --format json prints */
+func milestoneToJSON(milestone TMilestone) TJSONMilestone {
+	jmilestone := TJSONMilestone{
+		ID:           milestone.id,
+		Title:        milestone.title,
+		Description:  milestone.description,
+		State:        "open",
+		OpenIssues:   milestone.openIssues,
+		ClosedIssues: milestone.closedIssues,
+		URL:          milestone.url,
+	}
+
+	if milestone.is_closed {
+		jmilestone.State = "closed"
+	}
+
+	if !milestone.due.IsZero() {
+		due := milestone.due
+		jmilestone.DueOn = &due
+	}
+
+	return jmilestone
+}
+
+/* Describe when the milestone is due, like 'due 2018-12-31 (overdue)' */
+func describeDueDate(milestone TMilestone) string {
+	if milestone.due.IsZero() {
+		return ansiStyle("2", "no due date")
+	}
+
+	// The hosts keep only the date, at midnight UTC
+	desc := "due " + milestone.due.UTC().Format("2006-01-02")
+	if !milestone.is_closed && time.Now().After(milestone.due.AddDate(0, 0, 1)) {
+		desc += " " + ansiStyle("31;1", "(overdue)")
+	}
+
+	return desc
+}
+
+/* Print the milestones in 'milestones' */
+func printMilestones(ad ArgumentData, milestones []TMilestone) {
+	if isStructuredFormat(ad.format) {
+		list := newStructuredList(ad.format)
+		for _, milestone := range milestones {
+			list.add(milestoneToJSON(milestone))
+		}
+		list.finish()
+		return
+	}
+
+	if len(milestones) == 0 {
+		fmt.Println("No milestones")
+		return
+	}
+
+	width := 0
+	for _, milestone := range milestones {
+		if w := visibleWidth(milestone.title); w > width {
+			width = w
+		}
+	}
+
+	for _, milestone := range milestones {
+		title := ansiStyle("33;1", milestone.title)
+		if milestone.is_closed {
+			title = ansiStyle("31;1", milestone.title)
+		}
+
+		done := ""
+		if total := milestone.openIssues + milestone.closedIssues; total > 0 {
+			done = " (" + strconv.Itoa(milestone.closedIssues*100/total) + "% done)"
+		}
+
+		fmt.Printf("  %s%s  %d open, %d closed%s, %s\n", title,
+			strings.Repeat(" ", width-visibleWidth(milestone.title)),
+			milestone.openIssues, milestone.closedIssues, done,
+			describeDueDate(milestone))
+
+		if milestone.description != "" {
+			fmt.Println("    " + ansiStyle("2", strings.Replace(
+				strings.TrimSpace(milestone.description), "\n", "\n    ", -1)))
+		}
+	}
+}
+
+func _milestones(ctx context.Context, ad ArgumentData, args []string) {
+	command := "list"
+	if len(args) > 1 {
+		command = args[1]
+	}
+
+	switch command {
+	case "help":
+		fmt.Println(args[0] + " [list] [open|closed|all]")
+		fmt.Println(" List the milestones of the repository, with how many issues are")
+		fmt.Println(" open and closed in each one, and when they are due. Only the open")
+		fmt.Println(" ones, unless 'closed' or 'all' is given")
+		fmt.Println(args[0] + " create <title> [--due <YYYY-MM-DD>] [--description <text>]")
+		fmt.Println(args[0] + " edit <title> [--title <new_title>] [--due <YYYY-MM-DD>]")
+		fmt.Println("   [--description <text>]")
+		fmt.Println(args[0] + " close|reopen <title>")
+		fmt.Println()
+		fmt.Println(" Use 'issues milestone <title>' to list the issues of a milestone")
+		fmt.Println()
+
+	case "list", "open", "closed", "all":
+		if isTableFormat(ad.format) || ad.template != nil {
+			panic("The milestones can only be printed as text, json or ndjson")
+		}
+
+		state := command
+		if command == "list" {
+			state = "open"
+			if len(args) > 2 {
+				state = args[2]
+			}
+		}
+
+		if state != "open" && state != "closed" && state != "all" {
+			panic("Unknown milestone state " + state + ". Use open, closed or all")
+		}
+
+		milestones, err := getManagementHost(ctx, ad, "Milestones").DownloadMilestones(ctx,
+			ad.auth, state, true)
+		if err != nil {
+			panic(err)
+		}
+
+		startPager(ad)
+		printMilestones(ad, milestones)
+
+	case "create":
+		if len(args) < 3 {
+			panic("Milestone title not specified!")
+		}
+
+		milestone := TMilestone{
+			title:       args[2],
+			description: getOptionArg(args[3:], "--description", ""),
+		}
+		if due := getOptionArg(args[3:], "--due", ""); due != "" {
+			milestone.due = parseDueDate(due)
+		}
+
+		if err := getManagementHost(ctx, ad, "Milestones").CreateMilestone(ctx, ad.auth, milestone); err != nil {
+			panic(err)
+		}
+		printManagementResult(ad, "create-milestone", "Created the milestone "+
+			milestone.title)
+
+	case "edit", "close", "reopen":
+		if len(args) < 3 {
+			panic("Milestone title not specified!")
+		}
+
+		r := getManagementHost(ctx, ad, "Milestones")
+		milestone := getMilestone(ctx, ad, r, args[2])
+		title := milestone.title
+
+		switch command {
+		case "edit":
+			milestone.title = getOptionArg(args[3:], "--title", milestone.title)
+			milestone.description = getOptionArg(args[3:], "--description",
+				milestone.description)
+			if due := getOptionArg(args[3:], "--due", ""); due != "" {
+				milestone.due = parseDueDate(due)
+			}
+		case "close":
+			milestone.is_closed = true
+		case "reopen":
+			milestone.is_closed = false
+		}
+
+		if err := r.EditMilestone(ctx, ad.auth, milestone); err != nil {
+			panic(err)
+		}
+
+		switch command {
+		case "edit":
+			printManagementResult(ad, "edit-milestone", "Changed the milestone "+title)
+		case "close":
+			printManagementResult(ad, "close-milestone", "Closed the milestone "+title)
+		case "reopen":
+			printManagementResult(ad, "reopen-milestone", "Reopened the milestone "+title)
+		}
+
+	default:
+		panic("Unknown milestones command " + command)
+	}
+}
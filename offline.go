@@ -0,0 +1,234 @@
+package main
+
+/**
+ * Offline mode
+ *
+ * Decides where the issues we show come from: the local cache or the
+ * repository host. With --offline, or when the host can't be reached, only
+ * the cache is used, and we tell the user how old it is.
+ *
+ * Copyright (C) 2018 Arthur M
+ */
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+/* Check if the error happened because we couldn't reach the host at all
+ * (no network, DNS failure, connection refused, timeout...), and not
+ * because the host answered something we didn't like
+ */
+func isNetworkError(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var operr *net.OpError
+	var dnserr *net.DNSError
+	if errors.As(err, &operr) || errors.As(err, &dnserr) {
+		return true
+	}
+
+	var neterr net.Error
+	return errors.As(err, &neterr) && neterr.Timeout()
+}
+
+/* Describe how long ago 't' was, like '5 minutes ago' */
+func relativeTime(t time.Time) string {
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return "1 " + unit + " ago"
+		}
+		return strconv.Itoa(n) + " " + unit + "s ago"
+	}
+
+	d := time.Since(t)
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return plural(int(d/time.Minute), "minute")
+	case d < 24*time.Hour:
+		return plural(int(d/time.Hour), "hour")
+	case d < 30*24*time.Hour:
+		return plural(int(d/(24*time.Hour)), "day")
+	case d < 365*24*time.Hour:
+		return plural(int(d/(30*24*time.Hour)), "month")
+	default:
+		return plural(int(d/(365*24*time.Hour)), "year")
+	}
+}
+
+/* Open the cache for offline use
+ * Panics if nothing was ever synced, since we have nothing to show
+ */
+func openOfflineCache() *TIssueCache {
+	cache, err := openIssueCache(getCurrentRepository())
+	if err != nil {
+		panic(err)
+	}
+
+	if cache.LastSync.IsZero() {
+		panic("No issues were synced for this repository. " +
+			"Run 'shissue sync' while you are online")
+	}
+
+	return cache
+}
+
+/* Where the offline warnings go
+ * Full-screen commands change it, so the warnings don't mess the screen
+ */
+var warningOutput io.Writer = os.Stderr
+
+/* Tell the user we are showing cached data, and how old it is
+ * 'reason' is why, or nil if the user asked for offline mode
+ */
+func warnOffline(cache *TIssueCache, reason error) {
+	if reason != nil {
+		fmt.Fprintf(warningOutput, "Could not reach the repository host (%s)\n",
+			reason.Error())
+	}
+
+	fmt.Fprintf(warningOutput, "Offline: showing issues synced %s (%s)\n\n",
+		relativeTime(cache.LastSync),
+		cache.LastSync.Format("2006-01-02 15:04"))
+}
+
+/* Go to the cache because the host failed with 'err'
+ * Only if the failure is a network one, and we have something synced.
+ * If not, there's nothing we can do
+ */
+func fallbackToCache(err error) *TIssueCache {
+	if !isNetworkError(err) {
+		panic(err)
+	}
+
+	cache, cerr := openIssueCache(getCurrentRepository())
+	if cerr != nil || cache.LastSync.IsZero() {
+		panic(err)
+	}
+
+	warnOffline(cache, err)
+	return cache
+}
+
+/* Get the issues that match 'filter'
+ *
+ * They come from the cache if we are offline or if it's fresh, and from the
+ * host if not. If the host can't be reached, fall back to the cache.
+ */
+func loadIssues(ctx context.Context, ad ArgumentData, filter TIssueFilter) []TIssue {
+	issues, _ := loadIssuesWith(ctx, ad, nil, filter)
+	return issues
+}
+
+/* Like loadIssues(), but use the host 'r' if it's not nil, instead of
+ * finding it again. Finding it sets up the HTTP client again, and that
+ * can't be done while someone else uses it
+ *
+ * Return the host the issues came from, or nil if they came from the cache
+ */
+func loadIssuesWith(ctx context.Context, ad ArgumentData, r TRepoHost,
+	filter TIssueFilter) ([]TIssue, TRepoHost) {
+
+	if ad.offline {
+		cache := openOfflineCache()
+		warnOffline(cache, nil)
+		return cache.getIssues(filter), nil
+	}
+
+	if !ad.noCache {
+		cache, err := openIssueCache(getCurrentRepository())
+		if err == nil && cache.isFresh(getCacheTTL()) {
+			return cache.getIssues(filter), nil
+		}
+	}
+
+	if r == nil {
+		var err error
+		if r, err = findRepositoryHost(ctx, ad.auth); err != nil {
+			return fallbackToCache(err).getIssues(filter), nil
+		}
+	}
+
+	issues, err := r.DownloadAllIssues(ctx, ad.auth, filter)
+	if err != nil {
+		return fallbackToCache(err).getIssues(filter), nil
+	}
+
+	return issues, r
+}
+
+/* Get the issue numbered 'number' and its comments, from the same places
+ * loadIssues() gets them
+ *
+ * Panics if the issue doesn't exist, or if we are offline and it was never
+ * synced
+ */
+func loadIssue(ctx context.Context, ad ArgumentData, number uint) (*TIssue, []TIssueComment) {
+	issue, comments, _ := loadIssueWith(ctx, ad, nil, number)
+	return issue, comments
+}
+
+/* Like loadIssue(), but use the host 'r' if it's not nil, like
+ * loadIssuesWith() does
+ *
+ * Return the host the issue came from, or nil if it came from the cache
+ */
+func loadIssueWith(ctx context.Context, ad ArgumentData, r TRepoHost,
+	number uint) (*TIssue, []TIssueComment, TRepoHost) {
+
+	fromCache := func(cache *TIssueCache) (*TIssue, []TIssueComment, TRepoHost) {
+		issue, comments := cache.getIssue(number)
+		if issue == nil {
+			panic("Issue #" + strconv.Itoa(int(number)) + " was never synced")
+		}
+		return issue, comments, nil
+	}
+
+	if ad.offline {
+		cache := openOfflineCache()
+		warnOffline(cache, nil)
+		return fromCache(cache)
+	}
+
+	if !ad.noCache {
+		cache, err := openIssueCache(getCurrentRepository())
+		if err == nil && cache.isFresh(getCacheTTL()) {
+			if issue, comments := cache.getIssue(number); issue != nil {
+				return issue, comments, nil
+			}
+		}
+	}
+
+	if r == nil {
+		var err error
+		if r, err = findRepositoryHost(ctx, ad.auth); err != nil {
+			return fromCache(fallbackToCache(err))
+		}
+	}
+
+	issue, err := r.DownloadIssue(ctx, ad.auth, number)
+	if err != nil {
+		return fromCache(fallbackToCache(err))
+	}
+
+	if issue == nil {
+		panic("No issue found with that number")
+	}
+
+	comments, err := r.DownloadIssueComments(ctx, ad.auth, number)
+	if err != nil {
+		return fromCache(fallbackToCache(err))
+	}
+
+	return issue, comments, r
+}
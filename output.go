@@ -0,0 +1,401 @@
+package main
+
+/**
+ * Structured output
+ *
+ * Besides the colored text meant for humans, shissue can print what it
+ * gets in formats meant for scripts, chosen with --format:
+ *
+ *  - json: a single JSON document (an array, for lists)
+ *  - ndjson: one JSON object per line
+ *
+ * The field names here are a stable interface. Don't rename them.
+ *
+ * Issue lists can also be printed as tables, for spreadsheets and wikis:
+ *
+ *  - csv: comma-separated values, with a header row
+ *  - markdown: a Markdown table
+ *
+ * The table columns can be chosen with --columns.
+ *
+ * Copyright (C) 2018 Arthur M
+ */
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* Output formats */
+const (
+	formatText     = "text"
+	formatJSON     = "json"
+	formatNDJSON   = "ndjson"
+	formatCSV      = "csv"
+	formatMarkdown = "markdown"
+)
+
+/* All formats --format accepts */
+var outputFormats = []string{formatText, formatJSON, formatNDJSON,
+	formatCSV, formatMarkdown}
+
+/* Columns the table formats can have, and how to get them from an issue
+ * 'markdown' tells if the value is for a Markdown table, since some
+ * columns look better there in a different way
+ */
+var tableColumns = map[string]func(issue *TIssue, markdown bool) string{
+	"number": func(issue *TIssue, markdown bool) string {
+		if markdown {
+			return "#" + strconv.Itoa(int(issue.number))
+		}
+		return strconv.Itoa(int(issue.number))
+	},
+	"title": func(issue *TIssue, markdown bool) string {
+		return issue.name
+	},
+	"state": func(issue *TIssue, markdown bool) string {
+		if issue.is_closed {
+			return "closed"
+		}
+		return "open"
+	},
+	"author": func(issue *TIssue, markdown bool) string {
+		return issue.author
+	},
+	"assignees": func(issue *TIssue, markdown bool) string {
+		return strings.Join(issue.assignees, ", ")
+	},
+	"labels": func(issue *TIssue, markdown bool) string {
+		names := make([]string, 0, len(issue.labels))
+		for _, label := range issue.labels {
+			names = append(names, label.name)
+		}
+		return strings.Join(names, ", ")
+	},
+	"milestone": func(issue *TIssue, markdown bool) string {
+		return issue.milestone
+	},
+	"created": func(issue *TIssue, markdown bool) string {
+		return issue.creation.Local().Format("2006-01-02 15:04")
+	},
+	"updated": func(issue *TIssue, markdown bool) string {
+		return issue.updated.Local().Format("2006-01-02 15:04")
+	},
+	"url": func(issue *TIssue, markdown bool) string {
+		return issue.url
+	},
+}
+
+/* Columns of the tables if --columns isn't given */
+var defaultTableColumns = []string{"number", "title", "state", "author",
+	"assignees", "labels", "created", "url"}
+
+/* Parse a column list, like 'number,title,labels'
+ * Panics if a column doesn't exist
+ */
+func parseTableColumns(list string) []string {
+	columns := make([]string, 0)
+	for _, column := range strings.Split(list, ",") {
+		column = strings.ToLower(strings.TrimSpace(column))
+		if column == "" {
+			continue
+		}
+
+		if _, ok := tableColumns[column]; !ok {
+			panic("Unknown column " + column + ". Try some of: " +
+				strings.Join(defaultTableColumns, ", ") + ", updated, milestone")
+		}
+		columns = append(columns, column)
+	}
+
+	if len(columns) == 0 {
+		panic("No columns specified")
+	}
+
+	return columns
+}
+
+type TJSONLabel struct {
+	Name        string `json:"name"`
+	Color       string `json:"color"` // Hex color, like 'ff0000'
+	Description string `json:"description,omitempty"`
+}
+
+type TJSONMilestone struct {
+	ID           uint       `json:"id"`
+	Title        string     `json:"title"`
+	Description  string     `json:"description,omitempty"`
+	State        string     `json:"state"` // 'open' or 'closed'
+	DueOn        *time.Time `json:"due_on,omitempty"`
+	OpenIssues   int        `json:"open_issues"`
+	ClosedIssues int        `json:"closed_issues"`
+	URL          string     `json:"url,omitempty"`
+}
+
+type TJSONComment struct {
+	ID        uint      `json:"id"`
+	URL       string    `json:"url"`
+	Author    string    `json:"author"`
+	CreatedAt time.Time `json:"created_at"`
+	Body      string    `json:"body"`
+}
+
+type TJSONIssue struct {
+	Number    uint         `json:"number"`
+	Title     string       `json:"title"`
+	State     string       `json:"state"` // 'open' or 'closed'
+	URL       string       `json:"url"`
+	Author    string       `json:"author"`
+	Assignees []string     `json:"assignees"`
+	Labels    []TJSONLabel `json:"labels"`
+	Milestone string       `json:"milestone,omitempty"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+	Body      string       `json:"body"`
+
+	// Only when showing a single issue
+	Comments []TJSONComment `json:"comments,omitempty"`
+}
+
+/* A single issue, as 'issues <n>' prints it. Unlike in the lists, the
+ * comments are always there, even if there are none
+ */
+type TJSONIssueDetail struct {
+	TJSONIssue
+	Comments []TJSONComment `json:"comments"`
+}
+
+/* Result of an operation on an issue, like the ones 'push' sends */
+type TJSONOperation struct {
+	ID      uint   `json:"id,omitempty"` // Pending operation ID, if any
+	Kind    string `json:"kind"`
+	Issue   uint   `json:"issue,omitempty"`
+	Status  string `json:"status"` // 'done', 'pending' or 'conflict'
+	Message string `json:"message"`
+}
+
+/* Result of a 'sync' */
+type TJSONSync struct {
+	Updated  int       `json:"updated"` // Issues that changed since the last sync
+	Cached   int       `json:"cached"`  // Issues in the cache
+	SyncedAt time.Time `json:"synced_at"`
+}
+
+/* Something that references an issue */
+type TJSONReference struct {
+	Kind   string `json:"kind"` // 'pull request', 'merge request', 'issue' or 'commit'
+	Number uint   `json:"number,omitempty"`
+	Commit string `json:"commit,omitempty"`
+	Repo   string `json:"repo,omitempty"` // Only if it's another repository
+	Title  string `json:"title,omitempty"`
+	URL    string `json:"url,omitempty"`
+	State  string `json:"state,omitempty"`
+	Closes bool   `json:"closes"`
+}
+
+/* Something in the history of an issue: an event, or a comment */
+type TJSONEvent struct {
+	Kind      string          `json:"kind"` // Like 'labeled' or 'comment'
+	Actor     string          `json:"actor"`
+	CreatedAt time.Time       `json:"created_at"`
+	Label     *TJSONLabel     `json:"label,omitempty"`
+	User      string          `json:"user,omitempty"` // Who was (un)assigned
+	From      string          `json:"from,omitempty"` // Titles, when renamed
+	To        string          `json:"to,omitempty"`
+	Detail    string          `json:"detail,omitempty"`
+	Reference *TJSONReference `json:"reference,omitempty"`
+	Body      string          `json:"body,omitempty"` // Comment text
+	URL       string          `json:"url,omitempty"`
+}
+
+/* A label change, as --format json prints it */
+type TJSONLabelChange struct {
+	Action      string `json:"action"`
+	Name        string `json:"name"`
+	OldName     string `json:"old_name,omitempty"`
+	Color       string `json:"color"`
+	OldColor    string `json:"old_color,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+/* Check if 'format' is a structured format, meant for scripts */
+func isStructuredFormat(format string) bool {
+	return format == formatJSON || format == formatNDJSON
+}
+
+/* Check if 'format' is a table format, for issue lists */
+func isTableFormat(format string) bool {
+	return format == formatCSV || format == formatMarkdown
+}
+
+/* Convert an issue and its comments to their JSON representation */
+func issueToJSON(issue *TIssue, comments []TIssueComment) TJSONIssue {
+	jissue := TJSONIssue{
+		Number:    issue.number,
+		Title:     issue.name,
+		State:     "open",
+		URL:       issue.url,
+		Author:    issue.author,
+		Assignees: issue.assignees,
+		Labels:    make([]TJSONLabel, 0, len(issue.labels)),
+		Milestone: issue.milestone,
+		CreatedAt: issue.creation,
+		UpdatedAt: issue.updated,
+		Body:      issue.content,
+	}
+
+	if issue.is_closed {
+		jissue.State = "closed"
+	}
+
+	// Scripts like empty arrays better than nulls
+	if jissue.Assignees == nil {
+		jissue.Assignees = make([]string, 0)
+	}
+
+	for _, label := range issue.labels {
+		jissue.Labels = append(jissue.Labels, TJSONLabel{
+			Name:  label.name,
+			Color: label.hexColor(),
+		})
+	}
+
+	for _, comment := range comments {
+		jissue.Comments = append(jissue.Comments, TJSONComment{
+			ID:        comment.id,
+			URL:       comment.url,
+			Author:    comment.author,
+			CreatedAt: comment.creation,
+			Body:      comment.content,
+		})
+	}
+
+	return jissue
+}
+
+/* Print 'v' as JSON to the standard output */
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		panic(err)
+	}
+}
+
+/* Print 'v' in the structured format 'format' */
+func printStructured(format string, v interface{}) {
+	if format == formatNDJSON {
+		if err := json.NewEncoder(os.Stdout).Encode(v); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	printJSON(v)
+}
+
+/* A list being printed in a structured format
+ * With ndjson, each item is printed as soon as it's added, so scripts can
+ * read them while we work. With json, they are printed by finish()
+ */
+type TStructuredList struct {
+	format string
+	items  []interface{}
+}
+
+func newStructuredList(format string) *TStructuredList {
+	return &TStructuredList{format: format, items: make([]interface{}, 0)}
+}
+
+func (l *TStructuredList) add(item interface{}) {
+	if l.format == formatNDJSON {
+		printStructured(l.format, item)
+		return
+	}
+
+	l.items = append(l.items, item)
+}
+
+func (l *TStructuredList) finish() {
+	if l.format != formatNDJSON {
+		printJSON(l.items)
+	}
+}
+
+/* Print an issue list in the structured format 'format' */
+func printIssuesStructured(format string, issues []TIssue) {
+	list := newStructuredList(format)
+	for idx := range issues {
+		list.add(issueToJSON(&issues[idx], nil))
+	}
+	list.finish()
+}
+
+/* Print a single issue, with its comments, in the structured format
+ * 'format'
+ */
+func printIssueStructured(format string, issue *TIssue, comments []TIssueComment) {
+	jissue := TJSONIssueDetail{TJSONIssue: issueToJSON(issue, comments)}
+	jissue.Comments = jissue.TJSONIssue.Comments
+	if jissue.Comments == nil {
+		jissue.Comments = make([]TJSONComment, 0)
+	}
+
+	printStructured(format, jissue)
+}
+
+/* Escape a Markdown table cell
+ * Pipes would end the cell, and new lines the row
+ */
+func escapeMarkdownCell(s string) string {
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	s = strings.Replace(s, "|", "\\|", -1)
+	s = strings.Replace(s, "\r\n", "<br>", -1)
+	return strings.Replace(s, "\n", "<br>", -1)
+}
+
+/* Print the 'columns' of 'issues' as a table, in the format 'format' */
+func printIssuesTable(format string, columns []string, issues []TIssue) {
+	markdown := format == formatMarkdown
+
+	if markdown {
+		fmt.Println("| " + strings.Join(columns, " | ") + " |")
+		fmt.Println(strings.Repeat("| --- ", len(columns)) + "|")
+	}
+
+	w := csv.NewWriter(os.Stdout)
+	if !markdown {
+		if err := w.Write(columns); err != nil {
+			panic(err)
+		}
+	}
+
+	for idx := range issues {
+		row := make([]string, 0, len(columns))
+		for _, column := range columns {
+			row = append(row, tableColumns[column](&issues[idx], markdown))
+		}
+
+		if markdown {
+			for cidx := range row {
+				row[cidx] = escapeMarkdownCell(row[cidx])
+			}
+			fmt.Println("| " + strings.Join(row, " | ") + " |")
+			continue
+		}
+
+		if err := w.Write(row); err != nil {
+			panic(err)
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+/**
+ * Pager
+ *
+ * Like git, when we print to a terminal, the output goes through a pager,
+ * so long issue lists don't scroll away. The pager is the one in
+ * 'shissue.pager', or $PAGER, or 'less -R'. Setting it to '' or 'cat', or
+ * using --no-pager, disables it.
+ *
+ * Copyright (C) 2018 Arthur M
+ */
+
+import (
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+)
+
+var (
+	pagerMutex  sync.Mutex
+	pagerCmd    *exec.Cmd
+	pagerStdout *os.File // The real standard output, while the pager runs
+)
+
+/* Get the pager command, or an empty string if we shouldn't use one */
+func getPager() string {
+	if pager, err := getGitProperty("shissue.pager"); err == nil {
+		return pager
+	}
+
+	if pager, ok := os.LookupEnv("PAGER"); ok {
+		return pager
+	}
+
+	return "less -R"
+}
+
+/* Send the standard output through the pager, until stopPager() is called
+ * Does nothing if the output isn't a terminal, or if there's no pager
+ */
+func startPager(ad ArgumentData) {
+	if ad.noPager || !isTerminal(os.Stdout) {
+		return
+	}
+
+	pager := getPager()
+	if pager == "" || pager == "cat" {
+		return
+	}
+
+	pagerMutex.Lock()
+	defer pagerMutex.Unlock()
+
+	if pagerCmd != nil {
+		return
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return
+	}
+
+	cmd := exec.Command("/bin/sh", "-c", pager)
+	cmd.Stdin = r
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// Same defaults git uses: quit if everything fits in one screen, show
+	// colors, and don't clear the screen at the end
+	cmd.Env = os.Environ()
+	if _, ok := os.LookupEnv("LESS"); !ok {
+		cmd.Env = append(cmd.Env, "LESS=FRX")
+	}
+	if _, ok := os.LookupEnv("LV"); !ok {
+		cmd.Env = append(cmd.Env, "LV=-c")
+	}
+
+	if err := cmd.Start(); err != nil {
+		r.Close()
+		w.Close()
+		return
+	}
+	r.Close()
+
+	pagerCmd = cmd
+	pagerStdout = os.Stdout
+	os.Stdout = w
+}
+
+/* Close the pager input, and wait for the user to quit it
+ * Safe to call if there's no pager
+ */
+func stopPager() {
+	pagerMutex.Lock()
+	defer pagerMutex.Unlock()
+
+	if pagerCmd == nil {
+		return
+	}
+
+	os.Stdout.Close()
+	os.Stdout = pagerStdout
+	_ = pagerCmd.Wait()
+	pagerCmd = nil
+}
+
+/* Check if the write error 'err' happened because the pager was quit
+ * before we printed everything, which is how people stop reading
+ */
+func isBrokenPipe(err error) bool {
+	if perr, ok := err.(*os.PathError); ok {
+		err = perr.Err
+	}
+
+	return err == syscall.EPIPE
+}
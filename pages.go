@@ -0,0 +1,98 @@
+package main
+
+/**
+ * Concurrent page downloading, shared by all repository hosts
+ *
+ * When the host tells us how many pages a listing has, we don't need to
+ * follow the 'next' links one by one: we can download the remaining pages
+ * at the same time.
+ *
+ * Copyright (C) 2018 Arthur M
+ */
+
+import (
+	"context"
+	"sync"
+)
+
+/* Maximum number of pages downloaded at the same time
+ * Hosts don't like too many concurrent requests from the same user (Github
+ * even has a 'secondary' rate limit for it), so keep it small
+ */
+const maxPageWorkers = 4
+
+/* Download the pages 'first' to 'last' (inclusive), at most maxPageWorkers at
+ * the same time, until 'parent' is cancelled.
+ *
+ * 'fetch' downloads a single page. Since it's called concurrently, it must
+ * store what it downloaded somewhere indexed by the page number, so the
+ * caller can put everything back in order.
+ *
+ * On the first error, cancel the context passed to all other fetches that
+ * are still running, and return that error.
+ */
+func fetchPages(parent context.Context, first, last int,
+	fetch func(ctx context.Context, page int) error) error {
+
+	if last < first {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	workers := maxPageWorkers
+	if last-first+1 < workers {
+		workers = last - first + 1
+	}
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var ferr error
+
+	pagech := make(chan int)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for page := range pagech {
+				if err := fetch(ctx, page); err != nil {
+					once.Do(func() {
+						ferr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+feed:
+	for page := first; page <= last; page++ {
+		select {
+		case pagech <- page:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+
+	close(pagech)
+	wg.Wait()
+
+	return ferr
+}
+
+/* Get how many pages of 'perpage' items we need to download to get 'limit'
+ * items, clamped to 'total' pages. A 'limit' of 0 means no limit.
+ */
+func pagesNeeded(limit uint, perpage, total int) int {
+	if limit == 0 {
+		return total
+	}
+
+	needed := (int(limit) + perpage - 1) / perpage
+	if needed < total {
+		return needed
+	}
+
+	return total
+}
@@ -0,0 +1,355 @@
+package main
+
+/**
+ * Fuzzy issue picker
+ *
+ * 'issues pick' lets you choose an issue by typing parts of its number,
+ * title or labels, and prints its number, so it can be used in scripts
+ * like 'git checkout -b issue-$(shissue issues pick)'.
+ *
+ * Since the standard output is usually captured in that case, the picker
+ * draws on the terminal itself (/dev/tty), like fzf does.
+ *
+ * Copyright (C) 2018 Arthur M
+ */
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+/* An issue the picker can show, and how well it matches the query */
+type TPickItem struct {
+	issue     *TIssue
+	text      []rune // What the query is matched against
+	score     int
+	positions map[int]bool // Matched characters of 'text'
+}
+
+/* Check if the characters of 'pattern' appear in 'text', in order
+ * Return how good the match is (consecutive characters and word starts are
+ * better), and where the characters are in 'text'
+ */
+func fuzzyMatch(pattern, text []rune) (int, []int, bool) {
+	positions := make([]int, 0, len(pattern))
+	score := 0
+	pi := 0
+	for ti := 0; ti < len(text) && pi < len(pattern); ti++ {
+		if unicode.ToLower(text[ti]) != unicode.ToLower(pattern[pi]) {
+			continue
+		}
+
+		score++
+		if len(positions) > 0 && positions[len(positions)-1] == ti-1 {
+			score += 5
+		}
+		if ti == 0 || !(unicode.IsLetter(text[ti-1]) || unicode.IsDigit(text[ti-1])) {
+			score += 3
+		}
+
+		positions = append(positions, ti)
+		pi++
+	}
+
+	if pi < len(pattern) {
+		return 0, nil, false
+	}
+
+	// Matches close together are better than spread ones
+	if len(positions) > 0 {
+		score -= (positions[len(positions)-1] - positions[0]) / 4
+	}
+
+	return score, positions, true
+}
+
+/* Match 'query' against the items, and return the ones that match, the
+ * best first
+ * Each word of the query must match
+ */
+func filterPickItems(items []TPickItem, query string) []*TPickItem {
+	words := strings.Fields(query)
+	matched := make([]*TPickItem, 0, len(items))
+
+	for idx := range items {
+		item := &items[idx]
+		item.score = 0
+		item.positions = make(map[int]bool)
+
+		ok := true
+		for _, word := range words {
+			score, positions, wok := fuzzyMatch([]rune(word), item.text)
+			if !wok {
+				ok = false
+				break
+			}
+
+			item.score += score
+			for _, pos := range positions {
+				item.positions[pos] = true
+			}
+		}
+
+		if ok {
+			matched = append(matched, item)
+		}
+	}
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		if matched[i].score != matched[j].score {
+			return matched[i].score > matched[j].score
+		}
+		return matched[i].issue.number > matched[j].issue.number
+	})
+
+	return matched
+}
+
+/* Run 'args' as a shissue command, or as an issue subcommand */
+func runCommandArgs(ctx context.Context, ad ArgumentData, args []string) {
+	for _, c := range commands {
+		if c.name == args[0] {
+			c.function(ctx, ad, args)
+			return
+		}
+	}
+
+	for _, c := range issueCommands {
+		if c.name == args[0] {
+			c.function(ctx, ad, args)
+			return
+		}
+	}
+
+	panic("No command named " + args[0])
+}
+
+/* Let the user pick one of 'issues' on the terminal 'tty'
+ * Return nil if they gave up
+ */
+func pickIssue(ctx context.Context, tty *os.File, issues []TIssue) *TIssue {
+	items := make([]TPickItem, 0, len(issues))
+	for idx := range issues {
+		issue := &issues[idx]
+		text := "#" + strconv.Itoa(int(issue.number)) + " " + issue.name
+		for _, label := range issue.labels {
+			text += " [" + label.name + "]"
+		}
+		items = append(items, TPickItem{issue: issue, text: []rune(text)})
+	}
+
+	oldFile := terminalFile
+	terminalFile = tty
+	saveTerminal()
+	_, _ = runStty("-icanon", "-echo", "min", "1")
+	fmt.Fprint(tty, "\033[?1049h")
+	defer func() {
+		fmt.Fprint(tty, "\033[?1049l")
+		restoreTerminal()
+		terminalFile = oldFile
+	}()
+
+	// Read keys only when asked, like the TUI does, so nothing is read
+	// from the terminal once the issue is picked. With --exec, the command
+	// might need it
+	wantKeys := make(chan bool)
+	defer close(wantKeys)
+	keys := make(chan []byte, 1)
+	go func() {
+		buf := make([]byte, 64)
+		for range wantKeys {
+			n, err := tty.Read(buf)
+			if err != nil {
+				close(keys)
+				return
+			}
+			keys <- append([]byte(nil), buf[:n]...)
+		}
+	}()
+
+	query := ""
+	selected := 0
+	reading := false
+	for {
+		matched := filterPickItems(items, query)
+		if selected >= len(matched) {
+			selected = len(matched) - 1
+		}
+		if selected < 0 {
+			selected = 0
+		}
+
+		drawPicker(tty, query, matched, selected, len(items))
+
+		if !reading {
+			wantKeys <- true
+			reading = true
+		}
+
+		var buf []byte
+		select {
+		case <-ctx.Done():
+			return nil
+		case b, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			buf = b
+			reading = false
+		}
+
+		for _, key := range parseKeys(buf) {
+			switch key {
+			case "enter":
+				if len(matched) == 0 {
+					continue
+				}
+				return matched[selected].issue
+			case "esc":
+				return nil
+			case "up", "ctrl-p":
+				selected--
+			case "down", "ctrl-n", "tab":
+				selected++
+			case "backspace":
+				if r := []rune(query); len(r) > 0 {
+					query = string(r[:len(r)-1])
+				}
+				selected = 0
+			case "ctrl-u":
+				query, selected = "", 0
+			default:
+				if len([]rune(key)) == 1 {
+					query += key
+					selected = 0
+				}
+			}
+		}
+	}
+}
+
+/* Draw the picker: the query at the top, and the matching issues below */
+func drawPicker(tty *os.File, query string, matched []*TPickItem, selected, total int) {
+	rows, cols := terminalSize()
+
+	var b strings.Builder
+	b.WriteString("\033[H\033[2J")
+	b.WriteString(fitVisible("> "+query, cols))
+	b.WriteString("\r\n" + ansiStyle("2", fitVisible(fmt.Sprintf("  %d/%d",
+		len(matched), total), cols)))
+
+	// Keep the selected issue on the screen
+	height := rows - 2
+	top := 0
+	if selected >= height {
+		top = selected - height + 1
+	}
+
+	for idx := top; idx < len(matched) && idx < top+height; idx++ {
+		item := matched[idx]
+
+		var line strings.Builder
+		for pos, r := range item.text {
+			if item.positions[pos] {
+				line.WriteString(ansiStyle("1;32", string(r)))
+			} else {
+				line.WriteRune(r)
+			}
+		}
+
+		text := fitVisible(line.String(), cols-2)
+		if idx == selected {
+			// Keep the selection reversed after the highlighted characters
+			text = strings.Replace(text, "\033[0m", "\033[0m\033[7m", -1)
+			b.WriteString("\r\n" + ansiStyle("1;31", ">") + " " + "\033[7m" +
+				text + "\033[0m")
+		} else {
+			b.WriteString("\r\n  " + text)
+		}
+	}
+
+	// Put the cursor after the query
+	b.WriteString("\033[1;" + strconv.Itoa(len([]rune(query))+3) + "H")
+	fmt.Fprint(tty, b.String())
+}
+
+func _pickIssue(ctx context.Context, ad ArgumentData, args []string) {
+	if len(args) > 1 && args[1] == "help" {
+		fmt.Println(args[0] + " [filters] [--exec <command> [args...]]")
+		fmt.Println(" Choose an issue by typing parts of its number, title or labels, and")
+		fmt.Println(" print its number. The filters are the same of the issue listing.")
+		fmt.Println()
+		fmt.Println(" With --exec, run a shissue command on the issue instead. Everything")
+		fmt.Println(" after --exec is the command, and '{}' is replaced by the issue number.")
+		fmt.Println(" Without '{}', the number goes after the command name, like in")
+		fmt.Println(" '" + args[0] + " --exec comment -m \"Working on it\"'")
+		fmt.Println()
+		return
+	}
+
+	params := args[1:]
+	var exec []string
+	for idx, param := range params {
+		if param == "--exec" {
+			exec = params[idx+1:]
+			params = params[:idx]
+			if len(exec) == 0 {
+				panic("Command not specified for --exec")
+			}
+			break
+		}
+	}
+
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		panic("The issue picker needs a terminal")
+	}
+
+	issues := loadIssues(ctx, ad, parseIssueFilter(params))
+	if len(issues) == 0 {
+		panic("No issues found")
+	}
+
+	// We draw on the terminal, even if the output isn't one
+	colors := useColors
+	if ad.color == colorAuto {
+		useColors = os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb"
+	}
+	issue := pickIssue(ctx, tty, issues)
+	useColors = colors
+	tty.Close()
+
+	if issue == nil {
+		if ctx.Err() != nil {
+			panic(ctx.Err())
+		}
+		os.Exit(1)
+	}
+
+	number := strconv.Itoa(int(issue.number))
+	if exec == nil {
+		fmt.Println(number)
+		return
+	}
+
+	command := make([]string, 0, len(exec)+1)
+	replaced := false
+	for _, arg := range exec {
+		if strings.Contains(arg, "{}") {
+			arg = strings.Replace(arg, "{}", number, -1)
+			replaced = true
+		}
+		command = append(command, arg)
+	}
+
+	if !replaced {
+		command = append([]string{command[0], number}, command[1:]...)
+	}
+
+	runCommandArgs(ctx, ad, command)
+}
@@ -0,0 +1,231 @@
+package main
+
+/**
+ * Things that reference an issue
+ *
+ * When showing an issue, we list what references it: the local commits
+ * that mention it, the branches made for it ('issues start'), and the pull
+ * requests, merge requests and issues the host knows about.
+ *
+ * Copyright (C) 2018 Arthur M
+ */
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/* Get the regex part that matches a reference to the issue 'number', in
+ * the repository 'fullName' ('author/name') at 'weburl': '#42',
+ * 'author/name#42' or the issue URL
+ */
+func issueReferencePattern(fullName, weburl string, number uint) string {
+	n := strconv.Itoa(int(number))
+	return `(?:(?:` + regexp.QuoteMeta(fullName) + `)?#` + n + `|` +
+		regexp.QuoteMeta(weburl) + `(?:/-)?/issues/` + n + `)\b`
+}
+
+/* Get a regex that matches text that mentions the issue 'number' */
+func mentionReferenceRegex(fullName, weburl string, number uint) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)(?:^|[^\w/&])` +
+		issueReferencePattern(fullName, weburl, number))
+}
+
+/* Get a regex that matches text that closes the issue 'number' when it's
+ * merged, like 'Fixes #42'. The keywords are the ones Github and Gitlab
+ * both know
+ */
+func closingReferenceRegex(fullName, weburl string, number uint) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)\b(?:close[sd]?|fix(?:e[sd])?|resolve[sd]?):?\s+` +
+		issueReferencePattern(fullName, weburl, number))
+}
+
+/* Join the references in 'refs' and 'more', without repeating any
+ * If one is in both, we keep what we know from each of them
+ */
+func mergeIssueReferences(refs, more []TIssueReference) []TIssueReference {
+	merged := make([]TIssueReference, 0, len(refs)+len(more))
+	index := make(map[string]int)
+
+	for _, ref := range append(refs, more...) {
+		key := ref.kind + " " + ref.repo + "#" + strconv.Itoa(int(ref.number))
+		if ref.kind == "commit" {
+			key = "commit " + ref.id
+		}
+
+		idx, ok := index[key]
+		if !ok {
+			index[key] = len(merged)
+			merged = append(merged, ref)
+			continue
+		}
+
+		old := &merged[idx]
+		old.closes = old.closes || ref.closes
+		if old.title == "" {
+			old.title = ref.title
+		}
+		if old.url == "" {
+			old.url = ref.url
+		}
+		if old.state == "" {
+			old.state = ref.state
+		}
+	}
+
+	return merged
+}
+
+/* Find the commits in the local repository (in any branch) whose messages
+ * mention the issue 'number'
+ */
+func findCommitReferences(repo *TRepository, number uint) []TIssueReference {
+	fullName := repo.author + "/" + repo.name
+	weburl := repositoryWebURL(repo)
+	n := strconv.Itoa(int(number))
+
+	// Git finds the candidates, and we check them with our regexes, that
+	// git doesn't understand
+	out, err := exec.Command("git", "log", "--all", "-i", "-E",
+		"--format=%H%x1f%s%x1f%B%x1e",
+		"--grep=#"+n+"([^0-9]|$)",
+		"--grep=/issues/"+n+"([^0-9]|$)").Output()
+	if err != nil {
+		return nil
+	}
+
+	mentions := mentionReferenceRegex(fullName, weburl, number)
+	closes := closingReferenceRegex(fullName, weburl, number)
+
+	refs := make([]TIssueReference, 0)
+	for _, record := range strings.Split(string(out), "\x1e") {
+		fields := strings.SplitN(strings.TrimLeft(record, "\n"), "\x1f", 3)
+		if len(fields) < 3 || !mentions.MatchString(fields[2]) {
+			continue
+		}
+
+		refs = append(refs, TIssueReference{
+			kind:   "commit",
+			id:     fields[0],
+			title:  fields[1],
+			closes: closes.MatchString(fields[2]),
+		})
+	}
+
+	return refs
+}
+
+/* Find the branches linked to the issue 'number', by 'issues start' */
+func findLinkedBranches(number uint) []string {
+	out, err := exec.Command("git", "config", "--get-regexp",
+		`^branch\..*\.shissue-issue$`).Output()
+	if err != nil {
+		return nil
+	}
+
+	branches := make([]string, 0)
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[1] != strconv.Itoa(int(number)) {
+			continue
+		}
+
+		name := strings.TrimSuffix(strings.TrimPrefix(fields[0], "branch."),
+			".shissue-issue")
+		branches = append(branches, name)
+	}
+
+	return branches
+}
+
+/* Get what references the issue 'number': the host's references, when
+ * the issue came from the host 'r', and the local commits. 'r' is nil when
+ * it came from the cache, so we don't go online just for the references
+ *
+ * The references are only an extra to the issue, so if the host fails
+ * we show the ones we have
+ */
+func loadIssueReferences(ctx context.Context, ad ArgumentData, r TRepoHost,
+	number uint) []TIssueReference {
+
+	var refs []TIssueReference
+	if r != nil {
+		refs, _ = r.DownloadIssueReferences(ctx, ad.auth, number)
+	}
+
+	// The host knows the commits it has, but their subjects come from us
+	local := findCommitReferences(getCurrentRepository(), number)
+	return mergeIssueReferences(local, refs)
+}
+
+/* Print the 'Referenced by' section of an issue, with 'refs' and the
+ * branches 'branches'. Print nothing if there's nothing to print
+ */
+func printIssueReferences(refs []TIssueReference, branches []string) {
+	if len(refs) == 0 && len(branches) == 0 {
+		return
+	}
+
+	fmt.Println("\tReferenced by:")
+
+	// Pull requests and issues first, then commits
+	for _, commits := range []bool{false, true} {
+		for _, ref := range refs {
+			if (ref.kind == "commit") != commits {
+				continue
+			}
+
+			var line string
+			if ref.kind == "commit" {
+				id := ref.id
+				if len(id) > 7 {
+					id = id[:7]
+				}
+				line = "commit " + ansiStyle("33", id)
+			} else {
+				line = ref.kind + " " + ansiStyle("1", ref.repo+"#"+
+					strconv.Itoa(int(ref.number)))
+			}
+
+			if ref.title != "" {
+				line += " " + ref.title
+			}
+
+			notes := make([]string, 0, 2)
+			switch ref.state {
+			case "open":
+				notes = append(notes, ansiStyle("32", "open"))
+			case "merged":
+				notes = append(notes, ansiStyle("35", "merged"))
+			case "closed":
+				notes = append(notes, ansiStyle("31", "closed"))
+			}
+			if ref.closes {
+				notes = append(notes, "closes it")
+			}
+			if len(notes) > 0 {
+				line += " (" + strings.Join(notes, ", ") + ")"
+			}
+
+			fmt.Println("\t  " + line)
+			if ref.url != "" && ref.kind != "commit" {
+				fmt.Println("\t    " + ansiStyle("2", ref.url))
+			}
+		}
+	}
+
+	current, _ := exec.Command("git", "symbolic-ref", "-q", "--short", "HEAD").Output()
+	for _, branch := range branches {
+		line := "branch " + ansiStyle("36", branch)
+		if branch == strings.TrimSpace(string(current)) {
+			line += " (current)"
+		}
+		fmt.Println("\t  " + line)
+	}
+
+	fmt.Println()
+}
@@ -10,6 +10,10 @@ package main
  */
 
 import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -40,6 +44,49 @@ type TIssueLabel struct {
 	colorR, colorG, colorB uint8 // Color data, only for decoration
 }
 
+/* A label of the repository, with what only the label list has */
+type TRepoLabel struct {
+	TIssueLabel
+	description string // What the label means
+}
+
+/* Build a label from its name and its hex color, like 'ff0000' or '#ff0000'
+ * Invalid colors become white
+ */
+func newIssueLabel(name, hexcolor string) TIssueLabel {
+	hexcolor = strings.TrimPrefix(hexcolor, "#")
+	if len(hexcolor) != 6 {
+		hexcolor = "ffffff"
+	}
+
+	cR, _ := strconv.ParseUint(hexcolor[0:2], 16, 8)
+	cG, _ := strconv.ParseUint(hexcolor[2:4], 16, 8)
+	cB, _ := strconv.ParseUint(hexcolor[4:6], 16, 8)
+
+	return TIssueLabel{
+		name:   name,
+		colorR: uint8(cR),
+		colorG: uint8(cG),
+		colorB: uint8(cB),
+	}
+}
+
+/* Check if 'list' has 's', ignoring case, like the hosts do with labels */
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/* Get the label color as hex, like 'ff0000' */
+func (l TIssueLabel) hexColor() string {
+	return fmt.Sprintf("%02x%02x%02x", l.colorR, l.colorG, l.colorB)
+}
+
 /* The issue itself */
 type TIssue struct {
 	// Issue ID, in the repository host API
@@ -52,12 +99,18 @@ type TIssue struct {
 	author    string        // Issue author
 	assignees []string      // People assigned with the issue
 	labels    []TIssueLabel // Issue labels
+	milestone string        // Title of the issue milestone, if any
 
 	creation time.Time // Issue creation date
+	updated  time.Time // Last time the issue was updated
 
 	content string // Issue content
 
 	is_closed bool // Is the issue closed?
+
+	commentCount int // Number of comments
+
+	isPullRequest bool // Github lists pull requests as issues too
 }
 
 /* Error type that happened when you couldn't connect to your repository */
@@ -86,6 +139,48 @@ type TIssueComment struct {
 	content string // Comment content
 }
 
+/* Something that references an issue: a pull (or merge) request, another
+ * issue, or a commit
+ */
+type TIssueReference struct {
+	kind   string // 'pull request', 'merge request', 'issue' or 'commit'
+	number uint   // Number of the pull request or issue
+	id     string // Hash of the commit
+	repo   string // Repository, like 'author/name', if it's another one
+	title  string // Title, or commit subject
+	url    string // URL, to view it online
+	state  string // 'open', 'closed' or 'merged'
+	closes bool   // Does it close the issue (when merged)?
+}
+
+/* Something that happened to an issue, besides comments */
+type TIssueEvent struct {
+	// What happened: 'labeled', 'unlabeled', 'assigned', 'unassigned',
+	// 'closed', 'reopened', 'renamed', 'referenced', 'note' (a Gitlab
+	// system note), or what the host calls it
+	kind    string
+	actor   string    // Who did it
+	created time.Time // When it happened
+
+	label    TIssueLabel      // Label added or removed
+	user     string           // User assigned or unassigned
+	from, to string           // Old and new titles, when renamed
+	detail   string           // Text of notes, and of unknown events
+	ref      *TIssueReference // What referenced or closed the issue
+}
+
+/* A milestone, a group of issues to be done by a date */
+type TMilestone struct {
+	id          uint      // Milestone ID, in the repository host API
+	title       string    // Milestone title
+	description string    // What the milestone is
+	due         time.Time // When it should be done. Zero if it has no date
+	is_closed   bool      // Is the milestone closed?
+	url         string    // Milestone URL, to view it online
+
+	openIssues, closedIssues int // Issues in the milestone
+}
+
 /* Filter for the issue query
  * If any of the issue query filters are 'null', it means that it shouldn't be
  * considered
@@ -95,8 +190,22 @@ type TIssueFilter struct {
 	assignee *string // Only get issues assigned to 'assignee'
 	getOpen, getClosed bool // True if you want to get open or closed issues
 	creator *string // Only get issues made by 'creator'
+	since *time.Time // Only get issues updated at or after 'since'
+	search *string // Only get issues with this text in them
+	milestone *string // Only get issues in this milestone, or 'none' or 'any'
+	mentioned *string // Only get issues that mention 'mentioned'
+	until *time.Time // Only get issues updated at or before 'until'
+	createdSince *time.Time // Only get issues created at or after 'createdSince'
+	noLabels bool // Only get issues without labels
+	sortBy string // Sort by 'created' (the default), 'updated' or 'comments'
+	ascending bool // Sort oldest (or least commented) first
+	limit uint // Maximum number of issues to get, 0 for no limit
 }
 
+/* All methods receive a context. When it's cancelled (because the user
+ * pressed Ctrl-C, for example), they should stop what they are doing and
+ * return its error
+ */
 type TRepoHost interface {
 
 	/* "Initialize" the host, with info from the repository
@@ -106,7 +215,7 @@ type TRepoHost interface {
 	 * It needs to fill all fields of the 'repo' structure
 	 * Returns an error object on error
 	 */
-	Initialize(auth *TAuthentication, repo *TRepository) (string, error)
+	Initialize(ctx context.Context, auth *TAuthentication, repo *TRepository) (string, error)
 
 	/* Download all issues from the repository
 	 * You can use the TAuthentication struct to pass authentication info
@@ -117,12 +226,69 @@ type TRepoHost interface {
 	 * Return an issue list on success, or nil on issue list and an error
 	 * on error
 	 */
-	DownloadAllIssues(auth *TAuthentication, filter TIssueFilter) ([]TIssue, error)
+	DownloadAllIssues(ctx context.Context, auth *TAuthentication, filter TIssueFilter) ([]TIssue, error)
 
 	/* Download an specific issue by ID,
 	 */
-	DownloadIssue(auth *TAuthentication, id uint) (*TIssue, error)
+	DownloadIssue(ctx context.Context, auth *TAuthentication, id uint) (*TIssue, error)
 
 	/* Download all comments from that issue */
-	DownloadIssueComments(auth *TAuthentication, issue_id uint) ([]TIssueComment, error)
+	DownloadIssueComments(ctx context.Context, auth *TAuthentication, issue_id uint) ([]TIssueComment, error)
+
+	/* Create an issue with the title 'title', the content 'content' and
+	 * the labels 'labels'. Return the created issue
+	 */
+	CreateIssue(ctx context.Context, auth *TAuthentication, title, content string, labels []string) (*TIssue, error)
+
+	/* Add a comment to an issue. Return the created comment */
+	CreateIssueComment(ctx context.Context, auth *TAuthentication, issue_id uint, content string) (*TIssueComment, error)
+
+	/* Close the issue, or reopen it if 'closed' is false */
+	SetIssueState(ctx context.Context, auth *TAuthentication, issue_id uint, closed bool) error
+
+	/* Add the labels 'add' and remove the labels 'remove' from an issue */
+	EditIssueLabels(ctx context.Context, auth *TAuthentication, issue_id uint, add, remove []string) error
+
+	/* Add the users 'assignees' to the assignees of an issue
+	 * Send no users to assign the authenticated user
+	 */
+	AssignIssue(ctx context.Context, auth *TAuthentication, issue_id uint, assignees []string) error
+
+	/* Download the pull requests, merge requests and issues that mention
+	 * or close an issue
+	 */
+	DownloadIssueReferences(ctx context.Context, auth *TAuthentication, issue_id uint) ([]TIssueReference, error)
+
+	/* Download what happened to an issue, besides the comments, oldest
+	 * first
+	 */
+	DownloadIssueEvents(ctx context.Context, auth *TAuthentication, issue_id uint) ([]TIssueEvent, error)
+
+	/* Download all labels of the repository */
+	DownloadLabels(ctx context.Context, auth *TAuthentication) ([]TRepoLabel, error)
+
+	/* Create the label 'label' */
+	CreateLabel(ctx context.Context, auth *TAuthentication, label TRepoLabel) error
+
+	/* Change the label named 'name' into 'label': its name, color and
+	 * description. The issues that have it keep it
+	 */
+	EditLabel(ctx context.Context, auth *TAuthentication, name string, label TRepoLabel) error
+
+	/* Delete the label named 'name', removing it from every issue */
+	DeleteLabel(ctx context.Context, auth *TAuthentication, name string) error
+
+	/* Download the milestones of the repository in the state 'state'
+	 * ('open', 'closed' or 'all'). Their issue counts are only needed
+	 * with 'counts', since some hosts have to ask for each one
+	 */
+	DownloadMilestones(ctx context.Context, auth *TAuthentication, state string, counts bool) ([]TMilestone, error)
+
+	/* Create the milestone 'milestone' */
+	CreateMilestone(ctx context.Context, auth *TAuthentication, milestone TMilestone) error
+
+	/* Change the milestone with the ID of 'milestone' into 'milestone': its
+	 * title, description, due date and state
+	 */
+	EditMilestone(ctx context.Context, auth *TAuthentication, milestone TMilestone) error
 }
@@ -0,0 +1,313 @@
+package main
+
+/**
+ * Full-text issue search
+ *
+ * Online, the host does the search. Offline (or when the cache is fresh),
+ * we search the synced issues with an index of the words in their titles,
+ * contents and comments.
+ *
+ * Copyright (C) 2018 Arthur M
+ */
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+/* Index of the words in the cached issues
+ * Maps each word to the issues it appears in, and how many times
+ */
+type TSearchIndex struct {
+	words map[string]map[uint]int
+}
+
+/* Split a text into lowercase words, for indexing and searching */
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+/* Build the index of all issues in the cache */
+func buildSearchIndex(cache *TIssueCache) *TSearchIndex {
+	index := &TSearchIndex{words: make(map[string]map[uint]int)}
+
+	addText := func(number uint, text string) {
+		for _, word := range tokenize(text) {
+			if index.words[word] == nil {
+				index.words[word] = make(map[uint]int)
+			}
+			index.words[word][number]++
+		}
+	}
+
+	for number, cissue := range cache.Issues {
+		// Titles are more important than the rest
+		for i := 0; i < 3; i++ {
+			addText(number, cissue.Name)
+		}
+
+		addText(number, cissue.Content)
+		for _, comment := range cissue.Comments {
+			addText(number, comment.Content)
+		}
+	}
+
+	return index
+}
+
+/* Search the issues that have all words in 'query'
+ * Words match the start of indexed words, so 'crash' also finds 'crashes'
+ *
+ * Return the issue numbers, the ones with more matches first
+ */
+func (index *TSearchIndex) search(query string) []uint {
+	var scores map[uint]int
+
+	for _, term := range tokenize(query) {
+		termscores := make(map[uint]int)
+		for word, issues := range index.words {
+			if !strings.HasPrefix(word, term) {
+				continue
+			}
+
+			for number, count := range issues {
+				termscores[number] += count
+			}
+		}
+
+		// The issue needs to have all terms
+		if scores == nil {
+			scores = termscores
+			continue
+		}
+
+		for number := range scores {
+			if count, ok := termscores[number]; ok {
+				scores[number] += count
+			} else {
+				delete(scores, number)
+			}
+		}
+	}
+
+	numbers := make([]uint, 0, len(scores))
+	for number := range scores {
+		numbers = append(numbers, number)
+	}
+
+	sort.Slice(numbers, func(i, j int) bool {
+		si, sj := scores[numbers[i]], scores[numbers[j]]
+		if si != sj {
+			return si > sj
+		}
+		return numbers[i] > numbers[j]
+	})
+
+	return numbers
+}
+
+/* Find the places in 'text' where a word starts with one of 'terms'
+ * Return the [start, end) byte ranges of the matched words
+ */
+func findTerms(text string, terms []string) [][2]int {
+	matches := make([][2]int, 0)
+	lower := strings.ToLower(text)
+
+	start := -1
+	for pos, r := range lower + " " {
+		isword := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isword && start < 0 {
+			start = pos
+		}
+
+		if !isword && start >= 0 {
+			for _, term := range terms {
+				if strings.HasPrefix(lower[start:pos], term) {
+					matches = append(matches, [2]int{start, pos})
+					break
+				}
+			}
+			start = -1
+		}
+	}
+
+	return matches
+}
+
+/* Highlight the words of 'text' that start with one of 'terms', using the
+ * 'hl' function
+ */
+func highlightTerms(text string, terms []string, hl func(string) string) string {
+	return styleTerms(text, terms, hl, func(s string) string { return s })
+}
+
+/* Like highlightTerms(), but style the rest of the text with 'style'
+ * Styles can't be nested (a highlight ends all of them), so the rest of
+ * the text is styled in the parts between the highlights
+ */
+func styleTerms(text string, terms []string, hl, style func(string) string) string {
+	// Lowercasing might change the byte length of the text, and then the
+	// matches would be in the wrong place
+	if len(strings.ToLower(text)) != len(text) {
+		return style(text)
+	}
+
+	result := ""
+	last := 0
+	for _, match := range findTerms(text, terms) {
+		result += style(text[last:match[0]]) + hl(text[match[0]:match[1]])
+		last = match[1]
+	}
+
+	return result + style(text[last:])
+}
+
+/* Get the line of 'text' where the first of 'terms' is, highlighted, and
+ * cut to around 'width' characters
+ * Return an empty string if no term is found
+ */
+func searchSnippet(text string, terms []string, width int, hl func(string) string) string {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		matches := findTerms(line, terms)
+		if len(matches) == 0 || len(strings.ToLower(line)) != len(line) {
+			continue
+		}
+
+		// Center the snippet on the first match
+		start, end := 0, len(line)
+		prefix, suffix := "", ""
+		if len(line) > width {
+			start = matches[0][0] - width/3
+			if start < 0 {
+				start = 0
+			}
+
+			end = start + width
+			if end > len(line) {
+				end = len(line)
+			}
+
+			// Don't cut characters in half
+			for start > 0 && !isRuneStart(line[start]) {
+				start--
+			}
+			for end < len(line) && !isRuneStart(line[end]) {
+				end++
+			}
+
+			if start > 0 {
+				prefix = "..."
+			}
+			if end < len(line) {
+				suffix = "..."
+			}
+		}
+
+		return prefix + highlightTerms(line[start:end], terms, hl) + suffix
+	}
+
+	return ""
+}
+
+/* Check if the byte 'b' starts an UTF-8 character */
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+func _searchIssues(ctx context.Context, ad ArgumentData, args []string) {
+	if len(args) < 2 || args[1] == "help" {
+		fmt.Println(args[0] + " <query> [filters]")
+		fmt.Println(" Search the issue titles, contents and comments")
+		fmt.Println()
+		fmt.Println(" The filters are the same of the issue listing. Online, the host")
+		fmt.Println(" does the search. Offline, the synced issues are searched.")
+		fmt.Println()
+		return
+	}
+
+	query := args[1]
+	filter := parseIssueFilter(args[2:])
+	filter.search = &query
+
+	fnBold := func(s string) string {
+		return ansiStyle("37;1", s)
+	}
+
+	fnBoldRed := func(s string) string {
+		return ansiStyle("31;1", s)
+	}
+
+	fnYellow := func(s string) string {
+		return ansiStyle("33", s)
+	}
+
+	fnHighlight := func(s string) string {
+		return ansiStyle("30;43", s)
+	}
+
+	issues := loadIssues(ctx, ad, filter)
+	startPager(ad)
+	if isStructuredFormat(ad.format) {
+		printIssuesStructured(ad.format, issues)
+		return
+	}
+
+	if isTableFormat(ad.format) {
+		printIssuesTable(ad.format, ad.columns, issues)
+		return
+	}
+
+	if ad.template != nil {
+		for idx := range issues {
+			printIssueTemplate(ad.template, &issues[idx], nil)
+		}
+		return
+	}
+
+	if len(issues) == 0 {
+		fmt.Println("No issues found")
+		return
+	}
+
+	// The host doesn't tell us where it found the terms, so find them
+	// ourselves. Comments only exist in the cache
+	var cache *TIssueCache
+	if c, err := openIssueCache(getCurrentRepository()); err == nil {
+		cache = c
+	}
+
+	terms := tokenize(query)
+	for _, issue := range issues {
+		title := highlightTerms(issue.name, terms, fnHighlight)
+		if issue.is_closed {
+			title = styleTerms(issue.name, terms, fnHighlight, fnBoldRed)
+		}
+
+		fmt.Printf(" #"+fnBold("%d")+" %s (by "+fnYellow("%s")+")\n",
+			issue.number, title, issue.author)
+
+		snippet := searchSnippet(issue.content, terms, 72, fnHighlight)
+		if snippet == "" && cache != nil {
+			if _, comments := cache.getIssue(issue.number); comments != nil {
+				for _, comment := range comments {
+					snippet = searchSnippet(comment.content, terms, 72,
+						fnHighlight)
+					if snippet != "" {
+						snippet = comment.author + ": " + snippet
+						break
+					}
+				}
+			}
+		}
+
+		if snippet != "" {
+			fmt.Println("\t" + snippet)
+		}
+	}
+}
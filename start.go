@@ -0,0 +1,199 @@
+package main
+
+/**
+ * Starting to work on an issue
+ *
+ * 'issues start' creates a branch for an issue, and remembers which issue
+ * it is in the branch configuration ('branch.<name>.shissue-issue'), so
+ * other commands can know what you are working on.
+ *
+ * Copyright (C) 2018 Arthur M
+ */
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+/* Branch name pattern used when 'shissue.branchPattern' isn't set */
+const defaultBranchPattern = "{number}-{slug}"
+
+/* Maximum length of the title slug in branch names */
+const maxBranchSlug = 40
+
+/* Turn 'title' into something that can go in a branch name, like
+ * 'Crash when opening a file' into 'crash-when-opening-a-file'
+ */
+func branchSlug(title string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(title) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+
+	slug := b.String()
+	if len(slug) <= maxBranchSlug {
+		return slug
+	}
+
+	// Cut it in a word boundary, if there's one
+	slug = slug[:maxBranchSlug]
+	if idx := strings.LastIndex(slug, "-"); idx > 0 {
+		slug = slug[:idx]
+	}
+	return slug
+}
+
+/* Get the name of the branch of 'issue', from the pattern in
+ * 'shissue.branchPattern'. It knows {number}, {slug} and {author}
+ */
+func issueBranchName(issue *TIssue) string {
+	pattern, err := getGitProperty("shissue.branchPattern")
+	if err != nil || pattern == "" {
+		pattern = defaultBranchPattern
+	}
+
+	name := strings.NewReplacer(
+		"{number}", strconv.Itoa(int(issue.number)),
+		"{slug}", branchSlug(issue.name),
+		"{author}", branchSlug(issue.author),
+	).Replace(pattern)
+
+	out, err := exec.Command("git", "check-ref-format", "--branch", name).Output()
+	if err != nil {
+		panic("'" + name + "' is not a valid branch name. Check shissue.branchPattern")
+	}
+
+	return strings.TrimSpace(string(out))
+}
+
+/* Run git with 'args', showing what it prints to the user */
+func runGitVisible(args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+/* Get the issue numbered 'number', without its comments
+ * If the host can't be reached, use the cache
+ */
+func fetchIssue(ctx context.Context, ad ArgumentData, number uint) *TIssue {
+	var issue *TIssue
+	if ad.offline {
+		cache := openOfflineCache()
+		warnOffline(cache, nil)
+		issue, _ = cache.getIssue(number)
+	} else if r, err := findRepositoryHost(ctx, ad.auth); err != nil {
+		issue, _ = fallbackToCache(err).getIssue(number)
+	} else if issue, err = r.DownloadIssue(ctx, ad.auth, number); err != nil {
+		issue, _ = fallbackToCache(err).getIssue(number)
+	}
+
+	if issue == nil {
+		panic("No issue found with that number")
+	}
+
+	return issue
+}
+
+func _startIssue(ctx context.Context, ad ArgumentData, args []string) {
+	if len(args) < 2 || args[1] == "help" {
+		fmt.Println(args[0] + " <issue_num> [--assign|--no-assign] [--label <label>|--no-label]")
+		fmt.Println(" Create a branch for the issue and check it out, or check it out if")
+		fmt.Println(" it already exists. The branch remembers the issue it is for.")
+		fmt.Println()
+		fmt.Println(" --assign assigns the issue to you, and --label adds a label to it,")
+		fmt.Println(" like 'in progress'. Set them by default with 'git config")
+		fmt.Println(" shissue.startAssign true' and 'git config shissue.startLabel <label>'")
+		fmt.Println()
+		fmt.Println(" The branch name comes from 'shissue.branchPattern', '" +
+			defaultBranchPattern + "'")
+		fmt.Println(" by default. It can have {number}, {slug} (the title) and {author}")
+		fmt.Println()
+		return
+	}
+
+	number := parseIssueNumber(args)
+
+	assign := false
+	if value, err := getGitProperty("shissue.startAssign"); err == nil {
+		assign = value == "true" || value == "yes" || value == "1"
+	}
+	label, _ := getGitProperty("shissue.startLabel")
+
+	for idx := 2; idx < len(args); idx++ {
+		switch args[idx] {
+		case "--assign":
+			assign = true
+		case "--no-assign":
+			assign = false
+		case "--label", "-l":
+			if idx+1 >= len(args) {
+				panic("Label not specified!")
+			}
+			idx++
+			label = args[idx]
+		case "--no-label":
+			label = ""
+		default:
+			panic("Unknown option " + args[idx])
+		}
+	}
+
+	issue := fetchIssue(ctx, ad, number)
+	if issue.is_closed {
+		fmt.Fprintf(os.Stderr, "Warning: issue #%d is closed\n", number)
+	}
+
+	branch := issueBranchName(issue)
+	exists := exec.Command("git", "rev-parse", "--verify", "--quiet",
+		"refs/heads/"+branch).Run() == nil
+
+	var err error
+	if exists {
+		err = runGitVisible("checkout", branch)
+	} else {
+		err = runGitVisible("checkout", "-b", branch)
+	}
+	if err != nil {
+		panic("Could not check out the branch " + branch)
+	}
+
+	err = exec.Command("git", "config", "branch."+branch+".shissue-issue",
+		strconv.Itoa(int(number))).Run()
+	if err != nil {
+		panic(err)
+	}
+
+	if !isStructuredFormat(ad.format) {
+		fmt.Printf("Working on #%d (%s) in %s\n", number, issue.name, branch)
+	}
+
+	if assign {
+		runIssueOperation(ctx, ad, TPendingOp{Kind: opAssign, Issue: number})
+	}
+
+	for _, l := range issue.labels {
+		if strings.EqualFold(l.name, label) {
+			label = ""
+		}
+	}
+
+	if label != "" {
+		runIssueOperation(ctx, ad, TPendingOp{Kind: opLabel, Issue: number,
+			Labels: []string{label}})
+	}
+}
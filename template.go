@@ -0,0 +1,161 @@
+package main
+
+/**
+ * User-defined issue templates
+ *
+ * With --template, issues are printed with a Go text/template instead of
+ * the built-in modes. The template runs once for each issue, over the same
+ * fields --format json prints (.Number, .Title, .State, .Labels...).
+ *
+ * Templates can be given right in the command line, or by name. Named
+ * templates come from git config ('shissue.template.<name>') or from a
+ * file in the config directory ('~/.config/shissue/templates/<name>.tmpl')
+ *
+ * Copyright (C) 2018 Arthur M
+ */
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+/* Names a named template can have */
+var templateNameRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+/* ANSI codes the 'color' template function knows */
+var templateColors = map[string]string{
+	"bold":      "1",
+	"dim":       "2",
+	"underline": "4",
+	"black":     "30",
+	"red":       "31",
+	"green":     "32",
+	"yellow":    "33",
+	"blue":      "34",
+	"magenta":   "35",
+	"cyan":      "36",
+	"white":     "37",
+	"gray":      "90",
+}
+
+/* Functions templates can use, besides the text/template ones */
+var templateFuncs = template.FuncMap{
+	// {{.Title | color "bold red"}}
+	"color": func(colors string, s string) (string, error) {
+		codes := make([]string, 0)
+		for _, name := range strings.Fields(colors) {
+			code, ok := templateColors[strings.ToLower(name)]
+			if !ok {
+				return "", fmt.Errorf("unknown color '%s'", name)
+			}
+			codes = append(codes, code)
+		}
+
+		return ansiStyle(strings.Join(codes, ";"), s), nil
+	},
+
+	// {{chip (index .Labels 0)}}, or {{chips .Labels}} for all of them
+	"chip": func(label TJSONLabel) string {
+		return labelChip(newIssueLabel(label.Name, label.Color))
+	},
+	"chips": func(labels []TJSONLabel) string {
+		chips := make([]string, 0, len(labels))
+		for _, label := range labels {
+			chips = append(chips, labelChip(newIssueLabel(label.Name, label.Color)))
+		}
+		return strings.Join(chips, " ")
+	},
+
+	// {{ago .UpdatedAt}} gives '3 days ago'
+	"ago": relativeTime,
+
+	// {{date "2006-01-02" .CreatedAt}}
+	"date": func(layout string, t time.Time) string {
+		return t.Local().Format(layout)
+	},
+
+	// {{.Title | trunc 40}} cuts the title to 40 characters, with '...'
+	"trunc": func(n int, s string) string {
+		if utf8.RuneCountInString(s) <= n {
+			return s
+		}
+		if n <= 3 {
+			return string([]rune(s)[:n])
+		}
+		return string([]rune(s)[:n-3]) + "..."
+	},
+
+	// {{.Author | pad 12}} fills with spaces to 12 characters
+	"pad": func(n int, s string) string {
+		if count := utf8.RuneCountInString(s); count < n {
+			return s + strings.Repeat(" ", n-count)
+		}
+		return s
+	},
+
+	// {{.Assignees | join ", "}}
+	"join": func(sep string, list []string) string {
+		return strings.Join(list, sep)
+	},
+}
+
+/* Get the directory where the named template files are */
+func getTemplateDir() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "shissue", "templates")
+	}
+	return expandHome("~/.config/shissue/templates")
+}
+
+/* Get the template text for 'arg', given to --template
+ * If it's the name of a template, return that template. If not, 'arg' is
+ * the template itself
+ */
+func resolveTemplate(arg string) (string, error) {
+	if !templateNameRegex.MatchString(arg) {
+		return arg, nil
+	}
+
+	if text, err := getGitProperty("shissue.template." + arg); err == nil && text != "" {
+		return text, nil
+	}
+
+	path := filepath.Join(getTemplateDir(), arg+".tmpl")
+	if content, err := ioutil.ReadFile(path); err == nil {
+		return string(content), nil
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	return "", fmt.Errorf("No template named '%s'. Set it with 'git config "+
+		"shissue.template.%s <template>', or in %s", arg, arg, path)
+}
+
+/* Compile the issue template 'text' */
+func parseIssueTemplate(text string) (*template.Template, error) {
+	return template.New("issue").Funcs(templateFuncs).Parse(text)
+}
+
+/* Print an issue, and its comments if any, with the template 'tmpl'
+ * Like 'git log --format', every issue ends in a new line
+ */
+func printIssueTemplate(tmpl *template.Template, issue *TIssue, comments []TIssueComment) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, issueToJSON(issue, comments)); err != nil {
+		panic(err)
+	}
+
+	if !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+		buf.WriteByte('\n')
+	}
+
+	_, _ = os.Stdout.Write(buf.Bytes())
+}
@@ -0,0 +1,207 @@
+package main
+
+/**
+ * Terminal handling
+ *
+ * We mess with the terminal when asking for passwords (we disable echo), so
+ * we need to be sure we always put it back the way it was, even if the user
+ * presses Ctrl-C in the middle of it.
+ *
+ * Copyright (C) 2018 Arthur M
+ */
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	terminalMutex sync.Mutex
+	terminalSaved string // Terminal state, as 'stty -g' outputs it
+
+	// The terminal we change. The standard input, unless a command opens
+	// the terminal itself because its input or output are redirected
+	terminalFile = os.Stdin
+
+	// The terminal width for printed text, found once per run, since it
+	// takes running 'stty'
+	widthOnce sync.Once
+	widthCols int
+)
+
+/* Run 'stty' with the arguments 'args' on our terminal */
+func runStty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = terminalFile
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+/* Save the terminal state, so restoreTerminal() can put it back later */
+func saveTerminal() {
+	terminalMutex.Lock()
+	defer terminalMutex.Unlock()
+
+	if state, err := runStty("-g"); err == nil {
+		terminalSaved = state
+	}
+}
+
+/* Restore the terminal state saved by saveTerminal()
+ * Safe to call more than once, and from more than one goroutine
+ */
+func restoreTerminal() {
+	terminalMutex.Lock()
+	defer terminalMutex.Unlock()
+
+	if terminalSaved == "" {
+		return
+	}
+
+	_, _ = runStty(terminalSaved)
+	terminalSaved = ""
+}
+
+/* Read a password from the terminal, without echoing it
+ * Return the context error if 'ctx' is cancelled before the user types it
+ */
+func readPassword(ctx context.Context) (string, error) {
+	// gets() is made in a java-like way. Congrats, Go!
+	reader := bufio.NewReader(os.Stdin)
+
+	/* Disable echo for you to type password, then enable it */
+	saveTerminal()
+	defer restoreTerminal()
+	_, _ = runStty("-echo")
+
+	pwdch := make(chan string, 1)
+	go func() {
+		pwd, _ := reader.ReadString('\n')
+		pwdch <- strings.Trim(pwd, "\n\r")
+	}()
+
+	select {
+	case pwd := <-pwdch:
+		return pwd, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+/* Ask the user 'question', and return true if they answer yes
+ * If nobody can answer (the input isn't a terminal), the answer is no
+ */
+func askConfirmation(question string) bool {
+	if !isTerminal(os.Stdin) {
+		return false
+	}
+
+	fmt.Fprint(os.Stderr, question+" [y/N] ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+/* Let the user write a text in their editor, like git does for commit
+ * messages. 'template' is the initial file content. Lines starting with '#'
+ * are removed from the result.
+ *
+ * The editor is the same git uses (core.editor, $GIT_EDITOR, $VISUAL,
+ * $EDITOR...)
+ */
+func editText(template string) (string, error) {
+	bout, err := exec.Command("git", "var", "GIT_EDITOR").Output()
+	if err != nil {
+		return "", err
+	}
+	editor := strings.TrimSpace(string(bout))
+
+	file, err := ioutil.TempFile("", "shissue-*.md")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.WriteString(template)
+	file.Close()
+	if err != nil {
+		return "", err
+	}
+
+	// The editor can have arguments, so let the shell run it
+	cmd := exec.Command("/bin/sh", "-c", editor+` "$@"`, editor, file.Name())
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+
+	content, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		return "", err
+	}
+
+	lines := make([]string, 0)
+	for _, line := range strings.Split(string(content), "\n") {
+		if !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+
+	return strings.TrimSpace(strings.Join(lines, "\n")), nil
+}
+
+/* Get how many rows and columns the terminal has
+ * If we can't know (like when we aren't in a terminal), use $LINES and
+ * $COLUMNS, or the classic 24x80
+ */
+func terminalSize() (rows, cols int) {
+	if size, err := runStty("size"); err == nil {
+		// 'stty size' prints '<rows> <columns>'
+		if fields := strings.Fields(size); len(fields) == 2 {
+			r, rerr := strconv.Atoi(fields[0])
+			c, cerr := strconv.Atoi(fields[1])
+			if rerr == nil && cerr == nil && r > 0 && c > 0 {
+				return r, c
+			}
+		}
+	}
+
+	rows, cols = 24, 80
+	if r, err := strconv.Atoi(os.Getenv("LINES")); err == nil && r > 0 {
+		rows = r
+	}
+	if c, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && c > 0 {
+		cols = c
+	}
+
+	return rows, cols
+}
+
+/* Get how many columns the terminal has, when we started printing
+ * Full-screen views, that follow the size changes, use terminalSize()
+ */
+func terminalWidth() int {
+	widthOnce.Do(func() {
+		_, widthCols = terminalSize()
+	})
+	return widthCols
+}
+
+/* Check if 'f' is a terminal, and not a file or a pipe */
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
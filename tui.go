@@ -0,0 +1,813 @@
+package main
+
+/**
+ * Full-screen issue browser
+ *
+ * The 'tui' command shows the issue list on the left and the selected issue,
+ * with its comments, on the right. The list can be filtered while you type,
+ * and the issues can be commented, closed and reopened from there.
+ *
+ * It's made with plain ANSI escapes and stty, like the rest of the terminal
+ * handling, and gets everything through TRepoHost (or the cache), so it
+ * works with every host.
+ *
+ * Copyright (C) 2018 Arthur M
+ */
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"unicode/utf8"
+)
+
+/* Comments of an issue, loaded in the background */
+type TTUIComments struct {
+	number   uint
+	comments []TIssueComment
+	err      error
+}
+
+/* Keeps the last line written to it, to show offline warnings in the status
+ * bar instead of in the middle of the screen
+ */
+type TStatusWriter struct {
+	mutex sync.Mutex
+	last  string
+}
+
+func (w *TStatusWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for _, line := range strings.Split(string(p), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			w.last = line
+		}
+	}
+	return len(p), nil
+}
+
+/* Get the last line written, and forget it */
+func (w *TStatusWriter) take() string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	last := w.last
+	w.last = ""
+	return last
+}
+
+type TTUI struct {
+	ctx context.Context
+	ad  ArgumentData
+
+	title  string       // Shown in the top bar
+	filter TIssueFilter // Filter the issues were loaded with
+	all    []TIssue     // All loaded issues
+	shown  []*TIssue    // The issues that match the filter bar
+
+	selected   int // Index of the selected issue in 'shown'
+	listTop    int // Index of the first issue the list shows
+	previewTop int // First line the preview shows
+
+	filterText    string
+	filterBackup  string // Filter text before editing, to go back with Esc
+	editingFilter bool
+
+	status   string
+	warnings *TStatusWriter
+
+	// The host the issues came from, or nil if they came from the cache.
+	// It's found once: finding it sets up the HTTP client again, and the
+	// comments are loaded in the background with it
+	host TRepoHost
+
+	comments map[uint][]TIssueComment
+	loading  map[uint]bool
+	results  chan TTUIComments
+
+	rows, cols int
+}
+
+/* Run 'f', and return what it panicked with as an error */
+func catchPanic(f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if rerr, ok := r.(error); ok {
+				err = rerr
+			} else {
+				err = fmt.Errorf("%v", r)
+			}
+		}
+	}()
+
+	f()
+	return nil
+}
+
+/* Parse the keys in 'buf', read from the terminal
+ * Special keys get names, like 'up' or 'enter'. The others are themselves
+ */
+func parseKeys(buf []byte) []string {
+	escapes := map[string]string{
+		"[A": "up", "[B": "down", "[C": "right", "[D": "left",
+		"OA": "up", "OB": "down", "OC": "right", "OD": "left",
+		"[5~": "pgup", "[6~": "pgdown",
+		"[H": "home", "[F": "end", "[1~": "home", "[4~": "end",
+		"OH": "home", "OF": "end",
+	}
+
+	keys := make([]string, 0)
+	for len(buf) > 0 {
+		if buf[0] == 0x1b {
+			found := false
+			for seq, name := range escapes {
+				if strings.HasPrefix(string(buf[1:]), seq) {
+					keys = append(keys, name)
+					buf = buf[1+len(seq):]
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				keys = append(keys, "esc")
+				buf = buf[1:]
+			}
+			continue
+		}
+
+		switch buf[0] {
+		case '\r', '\n':
+			keys = append(keys, "enter")
+		case 0x7f, 0x08:
+			keys = append(keys, "backspace")
+		case '\t':
+			keys = append(keys, "tab")
+		case 0x0e:
+			keys = append(keys, "ctrl-n")
+		case 0x10:
+			keys = append(keys, "ctrl-p")
+		case 0x15:
+			keys = append(keys, "ctrl-u")
+		default:
+			r, size := utf8.DecodeRune(buf)
+			if r != utf8.RuneError && r >= ' ' {
+				keys = append(keys, string(r))
+			}
+			buf = buf[size:]
+			continue
+		}
+		buf = buf[1:]
+	}
+
+	return keys
+}
+
+/* Cut 's' to 'width' columns, and fill it with spaces up to that, keeping
+ * the ANSI escapes
+ */
+func fitVisible(s string, width int) string {
+	var b strings.Builder
+	count := 0
+	styled := false
+
+	for idx := 0; idx < len(s); {
+		if loc := ansiEscape.FindStringIndex(s[idx:]); loc != nil && loc[0] == 0 {
+			b.WriteString(s[idx : idx+loc[1]])
+			idx += loc[1]
+			styled = true
+			continue
+		}
+
+		if count >= width {
+			break
+		}
+
+		r, size := utf8.DecodeRuneInString(s[idx:])
+		if r == '\t' {
+			b.WriteString(" ")
+		} else {
+			b.WriteRune(r)
+		}
+		count++
+		idx += size
+	}
+
+	if styled {
+		b.WriteString("\033[0m")
+	}
+	if count < width {
+		b.WriteString(strings.Repeat(" ", width-count))
+	}
+
+	return b.String()
+}
+
+/* Build the filter of the filter bar text, like 'label:bug state:all crash'
+ * The keys are the filters of the issue listing, like 'sort:updated', and
+ * 'is:<state>' and 'no:assignee|label'. Words without a 'key:' are
+ * searched in the issue titles and contents
+ */
+func parseTUIFilter(text string, base TIssueFilter) (TIssueFilter, []string) {
+	filter := TIssueFilter{getOpen: base.getOpen, getClosed: base.getClosed}
+	terms := make([]string, 0)
+
+	for _, word := range strings.Fields(text) {
+		key, value := "", word
+		if idx := strings.Index(word, ":"); idx > 0 {
+			key, value = strings.ToLower(word[:idx]), word[idx+1:]
+		}
+
+		switch key {
+		case "is":
+			if value == "open" || value == "closed" || value == "all" {
+				setIssueFilterFlag(&filter, value)
+			}
+		case "no":
+			setIssueFilterFlag(&filter, "no-"+strings.ToLower(value))
+		default:
+			if _, ok := issueFilterOptions[key]; !ok {
+				terms = append(terms, tokenize(word)...)
+				continue
+			}
+
+			// Values still being typed, like 'sort:upd', are ignored until
+			// they are valid
+			_ = catchPanic(func() { setIssueFilterOption(&filter, key, value) })
+		}
+	}
+
+	return filter, terms
+}
+
+/* Apply the filter bar to the loaded issues */
+func (t *TTUI) applyFilter() {
+	filter, terms := parseTUIFilter(t.filterText, t.filter)
+
+	var selected uint
+	if t.selected < len(t.shown) {
+		selected = t.shown[t.selected].number
+	}
+
+	t.shown = make([]*TIssue, 0, len(t.all))
+	for idx := range t.all {
+		issue := &t.all[idx]
+		if !issueMatchesFilter(issue, filter) {
+			continue
+		}
+
+		text := issue.name + "\n" + issue.content
+		matches := true
+		for _, term := range terms {
+			if len(findTerms(text, []string{term})) == 0 {
+				matches = false
+				break
+			}
+		}
+
+		if matches {
+			t.shown = append(t.shown, issue)
+		}
+	}
+
+	if filter.sortBy != "" || filter.ascending {
+		sort.SliceStable(t.shown, func(i, j int) bool {
+			return issueSortsBefore(t.shown[i], t.shown[j], filter)
+		})
+	}
+
+	// Keep the same issue selected, if it's still there
+	t.selected = 0
+	for idx, issue := range t.shown {
+		if issue.number == selected {
+			t.selected = idx
+			break
+		}
+	}
+	t.previewTop = 0
+}
+
+/* Load the issues again. 'fresh' skips the cache */
+func (t *TTUI) reload(fresh bool) error {
+	ad := t.ad
+	ad.noCache = ad.noCache || fresh
+
+	filter := t.filter
+	filter.getOpen, filter.getClosed = true, true
+
+	err := catchPanic(func() {
+		var host TRepoHost
+		t.all, host = loadIssuesWith(t.ctx, ad, t.host, filter)
+		if host != nil {
+			t.host = host
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	t.comments = make(map[uint][]TIssueComment)
+	t.loading = make(map[uint]bool)
+	t.applyFilter()
+	t.status = fmt.Sprintf("Loaded %d issues", len(t.all))
+	return nil
+}
+
+/* Get the selected issue, or nil if there's none */
+func (t *TTUI) current() *TIssue {
+	if t.selected < len(t.shown) {
+		return t.shown[t.selected]
+	}
+	return nil
+}
+
+/* Start loading the comments of the selected issue, if needed */
+func (t *TTUI) loadComments() {
+	issue := t.current()
+	if issue == nil || t.loading[issue.number] {
+		return
+	}
+	if _, ok := t.comments[issue.number]; ok {
+		return
+	}
+
+	t.loading[issue.number] = true
+	number := issue.number
+	host := t.host
+	go func() {
+		var comments []TIssueComment
+		err := catchPanic(func() {
+			comments = t.downloadComments(host, number)
+		})
+		t.results <- TTUIComments{number: number, comments: comments, err: err}
+	}()
+}
+
+/* Get the comments of the issue 'number' from the host 'host', or from
+ * the cache if the issues came from it (when 'host' is nil) or if the
+ * host can't be reached
+ */
+func (t *TTUI) downloadComments(host TRepoHost, number uint) []TIssueComment {
+	var cache *TIssueCache
+	if host != nil {
+		comments, err := host.DownloadIssueComments(t.ctx, t.ad.auth, number)
+		if err == nil {
+			return comments
+		}
+		cache = fallbackToCache(err)
+	} else {
+		var err error
+		if cache, err = openIssueCache(getCurrentRepository()); err != nil {
+			panic(err)
+		}
+	}
+
+	issue, comments := cache.getIssue(number)
+	if issue == nil {
+		panic("The comments of issue #" + strconv.Itoa(int(number)) +
+			" were never synced")
+	}
+	return comments
+}
+
+/* Get the widths of the list and the preview */
+func (t *TTUI) paneWidths() (int, int) {
+	listw := t.cols * 2 / 5
+	if listw < 24 {
+		listw = 24
+	}
+	if listw > 60 {
+		listw = 60
+	}
+	if listw > t.cols-10 {
+		listw = t.cols / 2
+	}
+
+	return listw, t.cols - listw - 1
+}
+
+/* Get the lines of the preview of the selected issue */
+func (t *TTUI) previewLines(width int) []string {
+	issue := t.current()
+	if issue == nil {
+		return []string{"", "  No issues match the filter"}
+	}
+
+	lines := make([]string, 0)
+	lines = append(lines, wrapText(ansiStyle("1", issue.name), width, "", "")...)
+
+	state := ansiStyle("32;1", "open")
+	if issue.is_closed {
+		state = ansiStyle("31;1", "closed")
+	}
+	lines = append(lines, fmt.Sprintf("#%d %s, by %s, %s", issue.number,
+		state, ansiStyle("36;1", issue.author), relativeTime(issue.creation)))
+
+	if len(issue.labels) > 0 {
+		chips := make([]string, 0, len(issue.labels))
+		for _, label := range issue.labels {
+			chips = append(chips, labelChip(label))
+		}
+		lines = append(lines, strings.Join(chips, " "))
+	}
+
+	if len(issue.assignees) > 0 {
+		lines = append(lines, "Assigned to "+
+			ansiStyle("33", strings.Join(issue.assignees, ", ")))
+	}
+
+	lines = append(lines, "")
+	if t.ad.raw {
+		lines = append(lines, strings.Split(issue.content, "\n")...)
+	} else {
+		lines = append(lines, strings.Split(renderMarkdown(issue.content, width), "\n")...)
+	}
+
+	comments, ok := t.comments[issue.number]
+	if !ok {
+		return append(lines, "", ansiStyle("2", "Loading comments..."))
+	}
+
+	for _, comment := range comments {
+		lines = append(lines, "", ansiStyle("33", "── "+comment.author+", "+
+			relativeTime(comment.creation)+" ──"))
+
+		body := comment.content
+		if !t.ad.raw {
+			body = renderMarkdown(body, width-2)
+		}
+		for _, line := range strings.Split(body, "\n") {
+			lines = append(lines, "  "+line)
+		}
+	}
+
+	return lines
+}
+
+/* Draw the whole screen */
+func (t *TTUI) draw() {
+	var b strings.Builder
+	listw, previeww := t.paneWidths()
+	height := t.rows - 2
+
+	// Keep the selected issue visible
+	if t.selected < t.listTop {
+		t.listTop = t.selected
+	}
+	if t.selected >= t.listTop+height {
+		t.listTop = t.selected - height + 1
+	}
+
+	header := fmt.Sprintf(" %s  %d of %d issues", t.title, len(t.shown), len(t.all))
+	if t.filterText != "" && !t.editingFilter {
+		header += "  [" + t.filterText + "]"
+	}
+	b.WriteString("\033[H\033[7m" + fitVisible(header, t.cols) + "\033[0m")
+
+	preview := t.previewLines(previeww)
+	if t.previewTop > len(preview)-1 {
+		t.previewTop = len(preview) - 1
+	}
+	if t.previewTop < 0 {
+		t.previewTop = 0
+	}
+
+	for row := 0; row < height; row++ {
+		b.WriteString("\033[" + strconv.Itoa(row+2) + ";1H")
+
+		item := ""
+		if idx := t.listTop + row; idx < len(t.shown) {
+			issue := t.shown[idx]
+			item = fitVisible(fmt.Sprintf(" #%-5d %s", issue.number, issue.name), listw)
+			if idx == t.selected {
+				item = "\033[7m" + item + "\033[0m"
+			} else if issue.is_closed {
+				item = ansiStyle("31", item)
+			}
+		} else {
+			item = strings.Repeat(" ", listw)
+		}
+		b.WriteString(item)
+		b.WriteString(ansiStyle("2", "│"))
+
+		line := ""
+		if idx := t.previewTop + row; idx < len(preview) {
+			line = preview[idx]
+		}
+		b.WriteString(fitVisible(line, previeww))
+	}
+
+	b.WriteString("\033[" + strconv.Itoa(t.rows) + ";1H")
+	if t.editingFilter {
+		b.WriteString(fitVisible("/"+t.filterText, t.cols))
+		b.WriteString("\033[" + strconv.Itoa(t.rows) + ";" +
+			strconv.Itoa(utf8.RuneCountInString(t.filterText)+2) + "H\033[?25h")
+	} else {
+		status := t.status
+		if status == "" {
+			status = "j/k move  J/K scroll  / filter  o open  c comment  " +
+				"x close/reopen  r refresh  q quit"
+		}
+		b.WriteString(ansiStyle("2", fitVisible(status, t.cols)) + "\033[?25l")
+	}
+
+	fmt.Print(b.String())
+}
+
+/* Put the terminal in full-screen mode, reading each key as it's pressed */
+func (t *TTUI) enterScreen() {
+	saveTerminal()
+	_, _ = runStty("-icanon", "-echo", "min", "1")
+	fmt.Print("\033[?1049h\033[?25l")
+}
+
+/* Give the terminal back the way it was */
+func (t *TTUI) leaveScreen() {
+	fmt.Print("\033[?25h\033[?1049l")
+	restoreTerminal()
+}
+
+/* Run an operation on the selected issue, and show what happened */
+func (t *TTUI) runOperation(op TPendingOp) bool {
+	var result string
+	var id uint
+	err := catchPanic(func() {
+		result, id, _ = sendIssueOperationWith(t.ctx, t.ad, t.host, op)
+	})
+
+	switch {
+	case err != nil:
+		t.status = err.Error()
+		return false
+	case id != 0:
+		t.status = fmt.Sprintf("Offline. Saved %s as pending operation %d",
+			op.describe(), id)
+	default:
+		t.status = result
+	}
+
+	return true
+}
+
+/* Comment on the selected issue, in the editor */
+func (t *TTUI) comment() {
+	issue := t.current()
+	if issue == nil {
+		return
+	}
+
+	t.leaveScreen()
+	text, err := editText(fmt.Sprintf("\n# Write your comment on #%d (%s) above.\n"+
+		"# Lines starting with '#' will be ignored, and an empty text aborts.\n",
+		issue.number, issue.name))
+	t.enterScreen()
+
+	if err != nil {
+		t.status = err.Error()
+		return
+	}
+	if text == "" {
+		t.status = "Empty comment, nothing was sent"
+		return
+	}
+
+	if t.runOperation(TPendingOp{Kind: opComment, Issue: issue.number,
+		Content: text}) {
+		// Show the new comment next time
+		delete(t.comments, issue.number)
+	}
+}
+
+/* Close the selected issue, or reopen it if it's closed */
+func (t *TTUI) toggleState() {
+	issue := t.current()
+	if issue == nil {
+		return
+	}
+
+	kind := opClose
+	if issue.is_closed {
+		kind = opReopen
+	}
+
+	if t.runOperation(TPendingOp{Kind: kind, Issue: issue.number}) {
+		issue.is_closed = !issue.is_closed
+	}
+}
+
+/* Handle a key press. Return false to quit */
+func (t *TTUI) handleKey(key string) bool {
+	if t.editingFilter {
+		switch key {
+		case "enter":
+			t.editingFilter = false
+		case "esc":
+			t.filterText = t.filterBackup
+			t.editingFilter = false
+		case "backspace":
+			if r := []rune(t.filterText); len(r) > 0 {
+				t.filterText = string(r[:len(r)-1])
+			}
+		default:
+			if utf8.RuneCountInString(key) == 1 {
+				t.filterText += key
+			}
+		}
+
+		t.applyFilter()
+		return true
+	}
+
+	t.status = ""
+	_, previeww := t.paneWidths()
+	height := t.rows - 2
+
+	switch key {
+	case "q", "esc":
+		return false
+	case "j", "down":
+		if t.selected < len(t.shown)-1 {
+			t.selected++
+			t.previewTop = 0
+		}
+	case "k", "up":
+		if t.selected > 0 {
+			t.selected--
+			t.previewTop = 0
+		}
+	case "pgdown":
+		t.selected += height
+		if t.selected >= len(t.shown) {
+			t.selected = len(t.shown) - 1
+		}
+		t.previewTop = 0
+	case "pgup":
+		t.selected -= height
+		t.previewTop = 0
+	case "g", "home":
+		t.selected, t.previewTop = 0, 0
+	case "G", "end":
+		t.selected, t.previewTop = len(t.shown)-1, 0
+	case "J":
+		t.previewTop++
+	case "K":
+		t.previewTop--
+	case " ":
+		t.previewTop += height - 1
+		if lines := len(t.previewLines(previeww)); t.previewTop >= lines {
+			t.previewTop = lines - 1
+		}
+	case "b":
+		t.previewTop -= height - 1
+	case "/":
+		t.filterBackup = t.filterText
+		t.editingFilter = true
+	case "o":
+		if issue := t.current(); issue != nil {
+			if err := openBrowser(issue.url); err != nil {
+				t.status = err.Error()
+			} else {
+				t.status = "Opened " + issue.url
+			}
+		}
+	case "c":
+		t.comment()
+	case "x":
+		t.toggleState()
+	case "r":
+		if err := t.reload(true); err != nil {
+			t.status = err.Error()
+		}
+	}
+
+	if t.selected < 0 {
+		t.selected = 0
+	}
+	if t.previewTop < 0 {
+		t.previewTop = 0
+	}
+
+	return true
+}
+
+/* Run the browser until the user quits */
+func (t *TTUI) run() {
+	// Read keys only when asked, so nothing is stolen from the editor when
+	// we are commenting
+	wantKeys := make(chan bool)
+	keys := make(chan []byte)
+	go func() {
+		buf := make([]byte, 64)
+		for range wantKeys {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				close(keys)
+				return
+			}
+			keys <- append([]byte(nil), buf[:n]...)
+		}
+	}()
+
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer signal.Stop(winch)
+
+	reading := false
+	for {
+		t.loadComments()
+		if warning := t.warnings.take(); warning != "" {
+			t.status = warning
+		}
+		t.draw()
+
+		if !reading {
+			wantKeys <- true
+			reading = true
+		}
+
+		select {
+		case <-t.ctx.Done():
+			return
+
+		case <-winch:
+			t.rows, t.cols = terminalSize()
+
+		case res := <-t.results:
+			delete(t.loading, res.number)
+			if res.err != nil {
+				t.status = res.err.Error()
+				t.comments[res.number] = nil
+			} else {
+				t.comments[res.number] = res.comments
+			}
+
+		case buf, ok := <-keys:
+			if !ok {
+				return
+			}
+			reading = false
+
+			for _, key := range parseKeys(buf) {
+				if !t.handleKey(key) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func _runTUI(ctx context.Context, ad ArgumentData, args []string) {
+	if len(args) > 1 && args[1] == "help" {
+		fmt.Println(args[0] + " [filters]")
+		fmt.Println(" Browse the issues in a full-screen view")
+		fmt.Println()
+		fmt.Println(" The filters are the same of the issue listing. Press '/' to filter")
+		fmt.Println(" the list while you type, with words like 'label:bug', 'assignee:none',")
+		fmt.Println(" 'author:someone', 'state:open|closed|all', 'no:label', 'since:3d' or")
+		fmt.Println(" 'sort:updated' (the filters of the listing, with a ':'). Other words")
+		fmt.Println(" are searched in the issue titles and contents.")
+		fmt.Println()
+		fmt.Println(" Keys: j/k or arrows move, J/K or space/b scroll the issue,")
+		fmt.Println(" o opens it in the browser, c comments, x closes or reopens it,")
+		fmt.Println(" r loads the issues again and q quits")
+		fmt.Println()
+		return
+	}
+
+	if !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+		panic("The issue browser needs a terminal")
+	}
+
+	repo := getCurrentRepository()
+	t := &TTUI{
+		ctx:      ctx,
+		ad:       ad,
+		title:    repo.author + "/" + repo.name,
+		filter:   parseIssueFilter(args[1:]),
+		warnings: &TStatusWriter{},
+		results:  make(chan TTUIComments, 4),
+	}
+	t.rows, t.cols = terminalSize()
+
+	// The first load can say we are offline on the normal screen. After it,
+	// warnings go to the status bar
+	if err := t.reload(false); err != nil {
+		panic(err)
+	}
+
+	oldWarnings := warningOutput
+	warningOutput = t.warnings
+	defer func() { warningOutput = oldWarnings }()
+
+	t.enterScreen()
+	defer t.leaveScreen()
+	t.run()
+}